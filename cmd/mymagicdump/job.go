@@ -0,0 +1,140 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/pkg/mymagicdump"
+)
+
+// newJob returns the backup described by the dump flags.
+func newJob(opts *config.Options) (*mymagicdump.Job, error) {
+	events, err := opts.EventOutput()
+	if err != nil {
+		return nil, err
+	}
+	job := &mymagicdump.Job{
+		Name: opts.Job,
+		Connection: mymagicdump.Connection{
+			Host:                opts.Host,
+			Socket:              opts.Socket,
+			User:                opts.User,
+			Password:            opts.Password,
+			PasswordFile:        opts.PasswordFile,
+			PasswordEnv:         opts.PasswordEnv,
+			PasswordCommand:     opts.PasswordCommand,
+			DefaultsFile:        opts.DefaultsFile,
+			DefaultsGroupSuffix: opts.DefaultsGroupSuffix,
+		},
+		Databases:          opts.Databases,
+		AllDatabases:       opts.AllDatabases,
+		SeparateDumps:      opts.SeparateDumps,
+		Exclude:            opts.ExcludeTables,
+		ExcludeData:        opts.ExcludeTablesData,
+		Engine:             opts.Engine,
+		Layout:             opts.Layout,
+		ConsistentSnapshot: opts.ConsistentSnapshot,
+		RemoveDefiners:     opts.RemoveDefiners,
+		MysqldumpArgs:      opts.Passthrough,
+		Output:             opts.OutputPath,
+		Timestamped:        opts.Timestamped,
+		Retention: mymagicdump.Retention{
+			KeepLast:    opts.KeepLast,
+			KeepDaily:   opts.KeepDaily,
+			KeepWeekly:  opts.KeepWeekly,
+			KeepMonthly: opts.KeepMonthly,
+		},
+		Compression: mymagicdump.Compression{
+			Type:    opts.Compression,
+			Level:   opts.CompressionLevel,
+			Threads: opts.CompressionThreads,
+			Stream:  opts.Stream,
+		},
+		Upload: mymagicdump.Upload{
+			URLs:              opts.Upload,
+			DeleteAfterUpload: opts.DeleteAfterUpload,
+			S3: mymagicdump.S3{
+				Endpoint:     opts.S3Endpoint,
+				Region:       opts.S3Region,
+				PathStyle:    opts.S3PathStyle,
+				StorageClass: opts.S3StorageClass,
+				PartSize:     opts.S3PartSize,
+				DisableTLS:   opts.S3DisableTLS,
+			},
+			SFTP: mymagicdump.SFTP{
+				Identities: opts.SFTPIdentities,
+				KnownHosts: opts.SFTPKnownHosts,
+			},
+		},
+		NoManifest:    opts.NoManifest,
+		SHA256Sums:    opts.SHA256Sums,
+		Retries:       opts.Retries,
+		RetryInterval: time.Duration(opts.RetryInterval) * time.Second,
+		Parallel:      opts.Jobs,
+		DryRun:        opts.DryRun,
+		Notify: mymagicdump.Notify{
+			Emails:          opts.NotifyEmail,
+			OnFailure:       opts.NotifyOnFailure,
+			Webhooks:        opts.Webhooks,
+			WebhookSecret:   opts.WebhookSecret,
+			WebhookTemplate: opts.WebhookTemplate,
+			SMTP: mymagicdump.SMTP{
+				Host:     opts.SMTPHost,
+				Port:     opts.SMTPPort,
+				TLS:      opts.SMTPTLS,
+				User:     opts.SMTPUser,
+				Password: opts.SMTPPassword,
+				From:     opts.SMTPFrom,
+			},
+		},
+		Metrics: mymagicdump.Metrics{
+			TextfileDir: opts.MetricsTextfileDir,
+			Job:         opts.MetricsJob,
+		},
+		Events:  events,
+		Console: true,
+		Quiet:   opts.Silent,
+	}
+	if opts.Port != "" {
+		if job.Connection.Port, err = strconv.Atoi(opts.Port); err != nil {
+			return nil, fmt.Errorf("invalid --port %q", opts.Port)
+		}
+	}
+	if opts.Password == config.PromptPassword {
+		job.Connection.Password = ""
+		job.Connection.PromptPassword = true
+	}
+	if opts.Encrypt {
+		job.Encryption = &mymagicdump.Encryption{
+			Recipients:     opts.EncryptRecipients,
+			RecipientsFile: opts.RecipientsFile,
+			PassphraseFile: opts.PassphraseFile,
+		}
+	}
+	return job, nil
+}
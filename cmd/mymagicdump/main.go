@@ -24,16 +24,45 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/trustservers-hosting/mymagicdump/internal/config"
-	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+	"github.com/trustservers-hosting/mymagicdump/internal/daemon"
 	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/restore"
 	"github.com/trustservers-hosting/mymagicdump/internal/version"
+	"github.com/trustservers-hosting/mymagicdump/pkg/mymagicdump"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "extract":
+			runExtract(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "run":
+			runJobs(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		}
+	}
 	opts, err := config.ParseArgs()
 	if err != nil {
 		os.Exit(1)
@@ -42,18 +71,171 @@ func main() {
 		fmt.Fprintf(os.Stdout, "mymagicdump %s\n", version.String())
 		return
 	}
-	// Startup banner (respect --silent)
-	if !opts.Silent {
+	// Startup banner (respect --silent and events on stdout)
+	if !opts.Silent && !opts.EventsToStdout() {
 		fmt.Fprintf(os.Stdout, "mymagicdump Version %s\n", version.Version)
 		fmt.Fprintf(os.Stdout, "Copyright (c) 2025 TrustServers PC\n\n")
 	}
-	logging.SetVerbosity(opts.Silent, opts.Verbose)
-	r := dumper.NewRunner(opts)
-	if err := r.Prepare(); err != nil {
+	if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(dump(opts))
+}
+
+// setupLogging applies the verbosity and the log format and destination.
+func setupLogging(silent, verbose bool, opts config.LogOptions) error {
+	logging.SetVerbosity(silent, verbose)
+	err := logging.Configure(logging.Config{
+		Format:   opts.LogFormat,
+		File:     opts.LogFile,
+		MaxSize:  int64(opts.LogMaxSize) << 20,
+		MaxFiles: opts.LogMaxFiles,
+		Syslog:   opts.Syslog,
+		Journald: opts.Journald,
+	})
+	if err != nil {
+		logging.Error("Invalid logging settings: %v", err)
+	}
+	return err
+}
+
+// dump runs one backup and returns its exit code.
+func dump(opts *config.Options) int {
+	job, err := newJob(opts)
+	if err != nil {
+		logging.Error("%v", err)
+		return mymagicdump.ExitError
+	}
+	_, err = job.Run(context.Background())
+	var runErr *mymagicdump.RunError
+	if err != nil && !errors.As(err, &runErr) {
+		// The summary reports the failures of a run that started
 		logging.Error("Prepare failed: %v", err)
+	}
+	return mymagicdump.ExitCode(err)
+}
+
+// runJobs runs jobs of a jobs file one after the other. The exit code is the
+// one of the first job that failed.
+func runJobs(args []string) {
+	runOpts, err := config.ParseRunArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	jobs, err := config.LoadJobs(runOpts.Config)
+	if err != nil {
+		logging.Error("%v", err)
+		os.Exit(1)
+	}
+	names := runOpts.Jobs
+	if runOpts.All {
+		names = jobs.Names()
+	}
+	code := mymagicdump.ExitSuccess
+	for _, name := range names {
+		opts, err := jobs.Options(name, runOpts.Overrides)
+		if err != nil {
+			logging.Error("%v", err)
+			code = cmp.Or(code, mymagicdump.ExitError)
+			continue
+		}
+		if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
+			code = cmp.Or(code, mymagicdump.ExitError)
+			continue
+		}
+		logging.With("job", name).Info("Running job %s", name)
+		code = cmp.Or(code, dump(opts))
+	}
+	os.Exit(code)
+}
+
+// runConfig checks a jobs file and prints every problem found.
+func runConfig(args []string) {
+	opts, err := config.ParseConfigArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	jobs, err := config.LoadJobs(opts.Config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if problems := jobs.Validate(); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "%s: %d job(s) OK\n", opts.Config, len(jobs.Names()))
+}
+
+// runDaemon runs the scheduled jobs of a jobs file until SIGINT or SIGTERM.
+// Running jobs are allowed to finish; a second signal stops at once.
+func runDaemon(args []string) {
+	opts, err := config.ParseDaemonArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
+		os.Exit(1)
+	}
+	jobs, err := config.LoadJobs(opts.Config)
+	if err != nil {
+		logging.Error("%v", err)
+		os.Exit(1)
+	}
+	d, err := daemon.New(jobs, opts)
+	if err != nil {
+		logging.Error("%v", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	logging.Info("mymagicdump %s scheduling jobs of %s", version.Version, opts.Config)
+	if err := d.Run(ctx); err != nil {
+		logging.Error("%v", err)
+		os.Exit(1)
+	}
+}
+
+func runRestore(args []string) {
+	opts, err := config.ParseRestoreArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
+		os.Exit(1)
+	}
+	if err := restore.NewRestorer(opts).Run(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func runExtract(args []string) {
+	opts, err := config.ParseExtractArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
+		os.Exit(1)
+	}
+	if err := restore.NewExtractor(opts).Run(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func runVerify(args []string) {
+	opts, err := config.ParseVerifyArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := setupLogging(opts.Silent, opts.Verbose, opts.LogOptions); err != nil {
 		os.Exit(1)
 	}
-	if err := r.Run(); err != nil {
+	if err := restore.NewVerifier(opts).Run(); err != nil {
 		os.Exit(1)
 	}
 }
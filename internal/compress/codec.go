@@ -0,0 +1,261 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// codec is a stream compression algorithm. Level 0 always selects the codec's
+// default level. Codecs that are not parallel ignore the thread count.
+type codec struct {
+	name      string
+	minLevel  int
+	maxLevel  int
+	parallel  bool
+	newWriter func(w io.Writer, level, threads int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// format is a compression type as selected with --compression.
+type format struct {
+	ext string
+	// tar formats bundle every dump into one tarball, the others compress
+	// each dump file on its own. zip is handled separately.
+	tar   bool
+	codec *codec
+}
+
+var (
+	gzipCodec = &codec{
+		name:     "gzip",
+		minLevel: gzip.BestSpeed,
+		maxLevel: gzip.BestCompression,
+		parallel: true,
+		newWriter: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			if threads == 1 {
+				return gzip.NewWriterLevel(w, level)
+			}
+			// pigz-style: independent 1 MiB blocks compressed concurrently,
+			// still written as one standard gzip stream
+			pw, err := pgzip.NewWriterLevel(w, level)
+			if err != nil {
+				return nil, err
+			}
+			if err := pw.SetConcurrency(1<<20, threads); err != nil {
+				return nil, err
+			}
+			return pw, nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+	bzip2Codec = &codec{
+		name:     "bzip2",
+		minLevel: bzip2.BestSpeed,
+		maxLevel: bzip2.BestCompression,
+		newWriter: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = bzip2.BestCompression
+			}
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return bzip2.NewReader(r, nil)
+		},
+	}
+	zstdCodec = &codec{
+		name:     "zstd",
+		minLevel: 1,
+		maxLevel: 22,
+		parallel: true,
+		newWriter: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(threads)}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+	xzCodec = &codec{
+		name:     "xz",
+		minLevel: 1,
+		maxLevel: 9,
+		newWriter: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = 6
+			}
+			// Same dictionary sizes as the xz(1) presets
+			dictCaps := []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+			return xz.WriterConfig{DictCap: dictCaps[level]}.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(xr), nil
+		},
+	}
+	lz4Codec = &codec{
+		name:     "lz4",
+		minLevel: 1,
+		maxLevel: 9,
+		parallel: true,
+		newWriter: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			lw := lz4.NewWriter(w)
+			if err := lw.Apply(lz4.ConcurrencyOption(threads)); err != nil {
+				return nil, err
+			}
+			if level == 0 {
+				return lw, nil
+			}
+			levels := []lz4.CompressionLevel{lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+			if err := lw.Apply(lz4.CompressionLevelOption(levels[level-1])); err != nil {
+				return nil, err
+			}
+			return lw, nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(lz4.NewReader(r)), nil
+		},
+	}
+)
+
+var formats = map[string]format{
+	"tgz":  {ext: ".tar.gz", tar: true, codec: gzipCodec},
+	"tbz2": {ext: ".tar.bz2", tar: true, codec: bzip2Codec},
+	"tzst": {ext: ".tar.zst", tar: true, codec: zstdCodec},
+	"txz":  {ext: ".tar.xz", tar: true, codec: xzCodec},
+	"tlz4": {ext: ".tar.lz4", tar: true, codec: lz4Codec},
+	"zip":  {ext: ".zip"},
+	"gz":   {ext: ".gz", codec: gzipCodec},
+	"zst":  {ext: ".zst", codec: zstdCodec},
+	"none": {},
+}
+
+// Settings selects the compression type, level and number of compression
+// threads used for dump output. Threads 0 uses one thread per CPU. When
+// Recipients is set, streamed output is encrypted to them with age.
+type Settings struct {
+	Type       string
+	Level      int
+	Threads    int
+	Recipients []age.Recipient
+	// Progress, if set, is called by ApplyCompression with the number of
+	// bytes of the dump files it has just read
+	Progress func(n int64)
+}
+
+// Validate checks that the compression type is known and that the level is
+// within the range supported by its codec.
+func (s Settings) Validate() error {
+	if s.Type == "none" {
+		return nil
+	}
+	f, ok := formats[s.Type]
+	if !ok {
+		return fmt.Errorf("unsupported compression type: %s", s.Type)
+	}
+	minLevel, maxLevel := 1, 9
+	if f.codec != nil {
+		minLevel, maxLevel = f.codec.minLevel, f.codec.maxLevel
+	}
+	if s.Level != 0 && (s.Level < minLevel || s.Level > maxLevel) {
+		return fmt.Errorf("compression level %d out of range for %s (%d-%d)", s.Level, s.Type, minLevel, maxLevel)
+	}
+	if s.Threads < 0 {
+		return fmt.Errorf("compression threads cannot be negative")
+	}
+	if s.Threads != 1 && (f.codec == nil || !f.codec.parallel) {
+		logging.Warn("%s compression is single-threaded; ignoring --compression-threads.", s.Type)
+	}
+	return nil
+}
+
+// threads returns the number of compression threads to use.
+func (s Settings) threads() int {
+	if s.Threads == 0 {
+		return runtime.NumCPU()
+	}
+	return s.Threads
+}
+
+// Extension returns the file extension produced by a compression type.
+func Extension(compressionType string) string {
+	return formats[compressionType].ext
+}
+
+// Suffixes recognised when reading, longest first so .tar.gz wins over .gz.
+var suffixes = []struct{ suffix, compressionType string }{
+	{".tar.gz", "tgz"}, {".tgz", "tgz"},
+	{".tar.bz2", "tbz2"}, {".tbz2", "tbz2"},
+	{".tar.zst", "tzst"}, {".tzst", "tzst"},
+	{".tar.xz", "txz"}, {".txz", "txz"},
+	{".tar.lz4", "tlz4"},
+	{".zip", "zip"},
+	{".gz", "gz"},
+	{".zst", "zst"},
+}
+
+// Bundles reports whether a compression type puts all dumps into one archive.
+func Bundles(compressionType string) bool {
+	return compressionType == "zip" || formats[compressionType].tar
+}
+
+// Format returns the compression type (as accepted by ApplyCompression) of the
+// given path, judged by its file extension.
+func Format(path string) string {
+	lower := strings.ToLower(path)
+	for _, s := range suffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.compressionType
+		}
+	}
+	return "none"
+}
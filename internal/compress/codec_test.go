@@ -0,0 +1,55 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package compress
+
+import "testing"
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		wantErr  bool
+	}{
+		{name: "none", settings: Settings{Type: "none", Level: 99}},
+		{name: "default level", settings: Settings{Type: "tgz", Threads: 1}},
+		{name: "gzip level", settings: Settings{Type: "gz", Level: 9, Threads: 1}},
+		{name: "gzip level too high", settings: Settings{Type: "gz", Level: 10, Threads: 1}, wantErr: true},
+		{name: "zstd level", settings: Settings{Type: "tzst", Level: 22, Threads: 1}},
+		{name: "zstd level too high", settings: Settings{Type: "zst", Level: 23, Threads: 1}, wantErr: true},
+		{name: "negative level", settings: Settings{Type: "txz", Level: -1, Threads: 1}, wantErr: true},
+		{name: "zip level", settings: Settings{Type: "zip", Level: 9, Threads: 1}},
+		{name: "zip level too high", settings: Settings{Type: "zip", Level: 10, Threads: 1}, wantErr: true},
+		{name: "all CPUs", settings: Settings{Type: "tlz4", Threads: 0}},
+		{name: "negative threads", settings: Settings{Type: "tgz", Threads: -1}, wantErr: true},
+		{name: "unknown type", settings: Settings{Type: "rar"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
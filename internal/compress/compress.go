@@ -26,167 +26,381 @@ package compress
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/trustservers-hosting/mymagicdump/internal/logging"
-
-	"github.com/dsnet/compress/bzip2"
 )
 
-func ApplyCompression(outputPrefix string, compressionType string, files []string) {
+// ApplyCompression compresses the dump files according to settings. Errors
+// are logged as they happen; the returned error covers all of them.
+func ApplyCompression(outputPrefix string, settings Settings, files []string) error {
 	if len(files) == 0 {
 		logging.Info("No dump files produced; skipping compression.")
-		return
-	}
-	switch compressionType {
-	case "tgz":
-		compressTgz(outputPrefix, files)
-	case "tbz2":
-		compressTbz2(outputPrefix, files)
-	case "zip":
-		compressZip(outputPrefix, files)
-	case "none":
-		return
+		return nil
+	}
+	if settings.Type == "none" {
+		return nil
+	}
+	f, ok := formats[settings.Type]
+	switch {
+	case !ok:
+		logging.Error("Unsupported compression type: %s. Skipping compression.", settings.Type)
+		return fmt.Errorf("unsupported compression type %s", settings.Type)
+	case settings.Type == "zip":
+		return compressZip(outputPrefix, settings, files)
+	case f.tar:
+		return compressTar(outputPrefix, f, settings, files)
 	default:
-		logging.Error("Unsupported compression type: %s. Skipping compression.", compressionType)
+		return compressFiles(f, settings, files)
 	}
 }
 
-func compressTgz(outputPrefix string, files []string) {
-	logging.Info("Starting tar.gz compression for: %s", outputPrefix)
-	out, err := os.Create(outputPrefix + ".tar.gz")
-	if err != nil {
-		logging.Error("Failed to create tar.gz file: %v", err)
-		return
-	}
-	defer out.Close()
-	gz := gzip.NewWriter(out)
-	defer gz.Close()
-	tarw := tar.NewWriter(gz)
-	defer tarw.Close()
-	for _, f := range files {
-		fh, err := os.Open(f)
-		if err != nil {
-			logging.Error("Cannot open %s for compression: %v", f, err)
-			continue
-		}
-		fi, err := fh.Stat()
+func compressTar(outputPrefix string, ft format, settings Settings, files []string) error {
+	logging.Info("Starting %s compression for: %s", ft.ext[1:], outputPrefix)
+	write := func(out io.Writer) ([]entryInfo, error) {
+		cw, err := ft.codec.newWriter(out, settings.Level, settings.threads())
 		if err != nil {
-			logging.Error("Stat %s failed: %v", f, err)
-			fh.Close()
-			continue
+			return nil, fmt.Errorf("init %s writer: %w", ft.codec.name, err)
 		}
-		hdr, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
-			logging.Error("Header for %s failed: %v", f, err)
-			fh.Close()
-			continue
-		}
-		if err := tarw.WriteHeader(hdr); err != nil {
-			logging.Error("Write header for %s failed: %v", f, err)
-			fh.Close()
-			continue
+		tarw := tar.NewWriter(cw)
+		var entries []entryInfo
+		for _, f := range files {
+			e, err := addTarFile(tarw, f, entryName(outputPrefix, f), settings.Progress)
+			if err != nil {
+				cw.Close()
+				return nil, err
+			}
+			entries = append(entries, e)
 		}
-		if _, err := io.Copy(tarw, fh); err != nil {
-			logging.Error("Copy %s failed: %v", f, err)
-			fh.Close()
-			continue
-		}
-		fh.Close()
-		if err := os.Remove(f); err != nil {
-			logging.Warn("Failed to delete original %s after compression: %v", f, err)
+		if err := tarw.Close(); err != nil {
+			cw.Close()
+			return nil, err
 		}
+		return entries, cw.Close()
+	}
+	if err := writeArchive(outputPrefix+ft.ext, files, write, readTar(ft)); err != nil {
+		logging.Error("%s compression of %s failed: %v", ft.ext[1:], outputPrefix, err)
+		return err
 	}
-	logging.Info("tar.gz compression completed successfully.")
+	logging.Info("%s compression completed successfully.", ft.ext[1:])
+	return nil
 }
 
-func compressZip(outputPrefix string, files []string) {
-	logging.Info("Starting zip compression for: %s", outputPrefix)
-	out, err := os.Create(outputPrefix + ".zip")
+func addTarFile(tarw *tar.Writer, f, name string, progress func(int64)) (entryInfo, error) {
+	fh, err := os.Open(f)
 	if err != nil {
-		logging.Error("Failed to create zip file: %v", err)
-		return
+		return entryInfo{}, err
 	}
-	defer out.Close()
-	zw := zip.NewWriter(out)
-	defer zw.Close()
-	for _, f := range files {
-		base := filepath.Base(f)
-		fh, err := os.Open(f)
-		if err != nil {
-			logging.Error("Cannot open %s for zip: %v", f, err)
-			continue
-		}
-		w, err := zw.Create(base)
-		if err != nil {
-			logging.Error("Create zip entry %s failed: %v", f, err)
-			fh.Close()
-			continue
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return entryInfo{}, err
+	}
+	hdr, err := tar.FileInfoHeader(fi, fi.Name())
+	if err != nil {
+		return entryInfo{}, fmt.Errorf("header for %s: %w", f, err)
+	}
+	hdr.Name = name
+	if err := tarw.WriteHeader(hdr); err != nil {
+		return entryInfo{}, fmt.Errorf("write header for %s: %w", f, err)
+	}
+	return copyEntry(hdr.Name, tarw, fh, fi.Size(), progress)
+}
+
+func compressZip(outputPrefix string, settings Settings, files []string) error {
+	logging.Info("Starting zip compression for: %s", outputPrefix)
+	write := func(out io.Writer) ([]entryInfo, error) {
+		zw := zip.NewWriter(out)
+		if settings.Level != 0 {
+			zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, settings.Level)
+			})
 		}
-		if _, err := io.Copy(w, fh); err != nil {
-			logging.Error("Copy %s failed: %v", f, err)
-			fh.Close()
-			continue
-		}
-		fh.Close()
-		if err := os.Remove(f); err != nil {
-			logging.Warn("Failed to delete original %s after compression: %v", f, err)
+		var entries []entryInfo
+		for _, f := range files {
+			e, err := addZipFile(zw, f, entryName(outputPrefix, f), settings.Progress)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
 		}
+		return entries, zw.Close()
+	}
+	if err := writeArchive(outputPrefix+".zip", files, write, readZip); err != nil {
+		logging.Error("Zip compression of %s failed: %v", outputPrefix, err)
+		return err
 	}
 	logging.Info("Zip compression completed successfully.")
+	return nil
 }
 
-func compressTbz2(outputPrefix string, files []string) {
-	logging.Info("Starting tar.bz2 compression for: %s", outputPrefix)
-	out, err := os.Create(outputPrefix + ".tar.bz2")
+func addZipFile(zw *zip.Writer, f, name string, progress func(int64)) (entryInfo, error) {
+	fh, err := os.Open(f)
 	if err != nil {
-		logging.Error("Failed to create tar.bz2 file: %v", err)
-		return
+		return entryInfo{}, err
 	}
-	defer out.Close()
-	bz, err := bzip2.NewWriter(out, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+	defer fh.Close()
+	fi, err := fh.Stat()
 	if err != nil {
-		logging.Error("Failed to init bzip2 writer: %v", err)
-		return
+		return entryInfo{}, err
 	}
-	defer bz.Close()
-	tarw := tar.NewWriter(bz)
-	defer tarw.Close()
-	for _, f := range files {
-		fh, err := os.Open(f)
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	hdr.SetModTime(fi.ModTime())
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return entryInfo{}, fmt.Errorf("create zip entry %s: %w", f, err)
+	}
+	return copyEntry(hdr.Name, w, fh, fi.Size(), progress)
+}
+
+// entryName returns the archive member name of f: its path relative to the
+// directory of the archive, e.g. shop/orders_data.sql for a per-table dump.
+func entryName(outputPrefix, f string) string {
+	rel, err := filepath.Rel(filepath.Dir(outputPrefix), f)
+	if err != nil || !filepath.IsLocal(rel) {
+		return filepath.Base(f)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// compressFiles compresses every file on its own, e.g. db.sql -> db.sql.zst.
+func compressFiles(f format, settings Settings, files []string) error {
+	var errs []error
+	for _, file := range files {
+		logging.Info("Starting %s compression for: %s", f.codec.name, file)
+		if err := compressFile(file, f, settings); err != nil {
+			logging.Error("Failed to compress %s: %v", file, err)
+			errs = append(errs, fmt.Errorf("compress %s: %w", file, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	logging.Info("%s compression completed successfully.", f.codec.name)
+	return nil
+}
+
+func compressFile(file string, f format, settings Settings) error {
+	name := filepath.Base(file)
+	write := func(out io.Writer) ([]entryInfo, error) {
+		in, err := os.Open(file)
 		if err != nil {
-			logging.Error("Cannot open %s for compression: %v", f, err)
-			continue
+			return nil, err
 		}
-		fi, err := fh.Stat()
+		defer in.Close()
+		fi, err := in.Stat()
 		if err != nil {
-			logging.Error("Stat %s failed: %v", f, err)
-			fh.Close()
-			continue
+			return nil, err
 		}
-		hdr, err := tar.FileInfoHeader(fi, fi.Name())
+		cw, err := f.codec.newWriter(out, settings.Level, settings.threads())
 		if err != nil {
-			logging.Error("Header for %s failed: %v", f, err)
-			fh.Close()
-			continue
+			return nil, err
 		}
-		if err := tarw.WriteHeader(hdr); err != nil {
-			logging.Error("Write header for %s failed: %v", f, err)
-			fh.Close()
-			continue
+		e, err := copyEntry(name, cw, in, fi.Size(), settings.Progress)
+		if err != nil {
+			cw.Close()
+			return nil, err
 		}
-		if _, err := io.Copy(tarw, fh); err != nil {
-			logging.Error("Copy %s failed: %v", f, err)
-			fh.Close()
-			continue
+		return []entryInfo{e}, cw.Close()
+	}
+	return writeArchive(file+f.ext, []string{file}, write, readFile(f, name))
+}
+
+// readTar returns a function reading back the entries of a tar archive.
+func readTar(ft format) func(*os.File) ([]entryInfo, error) {
+	return func(in *os.File) ([]entryInfo, error) {
+		dr, err := ft.codec.newReader(in)
+		if err != nil {
+			return nil, err
 		}
-		fh.Close()
+		defer dr.Close()
+		var entries []entryInfo
+		err = walkTar(in.Name(), dr, func(name string, _ int64, r io.Reader) error {
+			e, err := readEntry(name, r)
+			entries = append(entries, e)
+			return err
+		})
+		return entries, err
+	}
+}
+
+// readZip reads back the entries of a zip archive.
+func readZip(in *os.File) ([]entryInfo, error) {
+	fi, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(in, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	// archive/zip also checks the CRC stored in the archive
+	var entries []entryInfo
+	err = walkZip(in.Name(), zr, func(name string, _ int64, r io.Reader) error {
+		e, err := readEntry(name, r)
+		entries = append(entries, e)
+		return err
+	})
+	return entries, err
+}
+
+// readFile returns a function reading back a single compressed file as the
+// entry name. Without a codec the file is read as is.
+func readFile(f format, name string) func(*os.File) ([]entryInfo, error) {
+	return func(in *os.File) ([]entryInfo, error) {
+		var r io.Reader = in
+		if f.codec != nil {
+			dr, err := f.codec.newReader(in)
+			if err != nil {
+				return nil, err
+			}
+			defer dr.Close()
+			r = dr
+		}
+		e, err := readEntry(name, r)
+		return []entryInfo{e}, err
+	}
+}
+
+// entryInfo identifies the content of an archive member.
+type entryInfo struct {
+	name string
+	size int64
+	crc  uint32
+}
+
+// copyEntry copies the file contents to w and returns the size and CRC-32 of
+// what was written. A file that does not have the expected size was changed
+// while archiving. progress, if not nil, is told about every read.
+func copyEntry(name string, w io.Writer, r io.Reader, size int64, progress func(int64)) (entryInfo, error) {
+	if progress != nil {
+		r = &progressReader{r: r, progress: progress}
+	}
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(w, io.TeeReader(r, crc))
+	if err != nil {
+		return entryInfo{}, fmt.Errorf("copy %s: %w", name, err)
+	}
+	if n != size {
+		return entryInfo{}, fmt.Errorf("%s changed size while archiving (%d != %d bytes)", name, n, size)
+	}
+	return entryInfo{name, n, crc.Sum32()}, nil
+}
+
+// progressReader reports the number of bytes of every read.
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return n, err
+}
+
+// readEntry reads an archive member and returns its size and CRC-32.
+func readEntry(name string, r io.Reader) (entryInfo, error) {
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, r)
+	if err != nil {
+		return entryInfo{}, fmt.Errorf("read %s: %w", name, err)
+	}
+	return entryInfo{name, n, crc.Sum32()}, nil
+}
+
+// writeArchive creates target transactionally: write fills a temporary file
+// next to it, which is synced to disk, read back with read and compared to
+// the entries write reported. Only then is it renamed to target and are the
+// original files removed. On failure the originals are left untouched.
+func writeArchive(target string, files []string, write func(io.Writer) ([]entryInfo, error), read func(*os.File) ([]entryInfo, error)) error {
+	tmp := target + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	want, err := write(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := finishArchive(tmp, target, want, read); err != nil {
+		return err
+	}
+	for _, f := range files {
 		if err := os.Remove(f); err != nil {
 			logging.Warn("Failed to delete original %s after compression: %v", f, err)
 		}
 	}
-	logging.Info("tar.bz2 compression completed successfully.")
+	return nil
+}
+
+// finishArchive reads back the synced temporary file tmp with read and
+// compares it to want, then renames it to target. A nil read skips the check,
+// e.g. for encrypted output that cannot be decrypted here. tmp is removed on
+// failure.
+func finishArchive(tmp, target string, want []entryInfo, read func(*os.File) ([]entryInfo, error)) error {
+	var err error
+	if read != nil {
+		err = verifyArchive(tmp, want, read)
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(target))
+	if read != nil {
+		logging.Debug("Verified %s (%d entries)", target, len(want))
+	}
+	return nil
+}
+
+// verifyArchive re-reads the archive at path and checks that it holds exactly
+// the expected entries.
+func verifyArchive(path string, want []entryInfo, read func(*os.File) ([]entryInfo, error)) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	got, err := read(in)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("verify: archive has %d entries, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("verify: entry %s does not match %s (size %d/%d, crc %08x/%08x)",
+				got[i].name, want[i].name, got[i].size, want[i].size, got[i].crc, want[i].crc)
+		}
+	}
+	return nil
+}
+
+// syncDir flushes a directory so that a rename in it survives a crash. Not
+// every platform supports this, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 }
@@ -0,0 +1,144 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/encrypt"
+)
+
+// EntryFunc is called for every member of a dump file or archive. The reader
+// is only valid until the function returns; members that are not read are skipped.
+type EntryFunc func(name string, size int64, r io.Reader) error
+
+// WalkArchive calls fn for each member of path in archive order. Plain and
+// single compressed files are reported as one member named after the file.
+// Files ending in .age are decrypted on the fly with the given identities.
+func WalkArchive(path string, identities []age.Identity, fn EntryFunc) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	var content io.ReaderAt = in
+	size := fi.Size()
+	name := filepath.Base(path)
+	if plain, ok := strings.CutSuffix(name, encrypt.Ext); ok {
+		if len(identities) == 0 {
+			return fmt.Errorf("%s is encrypted; an identity or passphrase is required", path)
+		}
+		content, size, err = age.DecryptReaderAt(in, size, identities...)
+		if err != nil {
+			return fmt.Errorf("decrypt %s: %w", path, err)
+		}
+		name = plain
+	}
+
+	compressionType := Format(name)
+	f := formats[compressionType]
+	switch {
+	case compressionType == "zip":
+		zr, err := zip.NewReader(content, size)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", path, err)
+		}
+		return walkZip(path, zr, fn)
+	case f.tar:
+		dr, err := f.codec.newReader(io.NewSectionReader(content, 0, size))
+		if err != nil {
+			return fmt.Errorf("open %s: %w", path, err)
+		}
+		defer dr.Close()
+		return walkTar(path, dr, fn)
+	case f.codec != nil:
+		// Single compressed file; its uncompressed size is unknown
+		dr, err := f.codec.newReader(io.NewSectionReader(content, 0, size))
+		if err != nil {
+			return fmt.Errorf("open %s: %w", path, err)
+		}
+		defer dr.Close()
+		return fn(DumpName(name), -1, dr)
+	}
+	return fn(name, size, io.NewSectionReader(content, 0, size))
+}
+
+// DumpName returns the name of the dump stored in a plain or single compressed
+// file, e.g. shop_data.sql.zst.age -> shop_data.sql.
+func DumpName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), encrypt.Ext)
+	if f := formats[Format(name)]; f.codec != nil && !f.tar {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+func walkTar(path string, r io.Reader, fn EntryFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(path string, zr *zip.Reader, fn EntryFunc) error {
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("open %s in %s: %w", zf.Name, path, err)
+		}
+		err = fn(zf.Name, int64(zf.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,484 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/encrypt"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// Archive receives dump output while mysqldump is still running, so that no
+// uncompressed copy of the dump is ever written to disk.
+//
+// Tar entries need their size up front, so every entry is first spooled through
+// a fast compressor into a temporary file next to the archive and appended to
+// the tar stream once the dump has finished. This needs disk space for the
+// spooled dump and compresses the data twice, once for the spool and once
+// for the archive. Zip entries are spooled as raw deflate data and copied
+// into the archive without being recompressed. Formats that compress each
+// dump on its own (gz, zst, none) are written directly.
+//
+// Like the archives of ApplyCompression, every output file is written to a
+// .tmp file next to its final name, synced to disk, read back and compared to
+// what was written, and only then renamed into place. Encrypted output cannot
+// be read back without the identity and is only synced.
+//
+// When encryption is enabled the archive is written through age, and spool
+// files are encrypted with a throwaway key that only lives in memory.
+//
+// Entries may be written concurrently. Each one has a sequence number and is
+// added to the archive in that order, whatever order they are committed in,
+// so the archive layout does not depend on which dump finishes first.
+type Archive struct {
+	path       string
+	tmp        string
+	dir        string
+	format     format
+	settings   Settings
+	out        *os.File
+	sink       io.WriteCloser
+	compressor io.WriteCloser
+	tarw       *tar.Writer
+	zipw       *zip.Writer
+	spoolKey   *age.X25519Identity
+	files      []string
+	// entries are the entries added so far, to verify the archive against
+	entries []entryInfo
+
+	mu sync.Mutex
+	// next is the sequence number of the next entry to add; ready holds
+	// committed entries waiting for their turn and done the positions that
+	// are final without an archive entry (skipped, or per-file output)
+	next  int
+	ready map[int]*Entry
+	done  map[int]string
+	// err is the first error adding a waiting entry to the archive
+	err error
+}
+
+// Entry is a single archive member being written. It is only added to the
+// archive by Commit; Discard drops it, e.g. when a dump attempt fails.
+type Entry struct {
+	archive *Archive
+	seq     int
+	name    string
+	// path is the final name of a per-file entry, which is written to file
+	// under a temporary name
+	path    string
+	file    *os.File
+	w       io.Writer
+	closers []io.Closer
+	crc     hash.Hash32
+	size    atomic.Int64
+	// compressed counts the raw deflate bytes of zip entries
+	compressed int64
+}
+
+// NewArchive creates outputPrefix plus the extension of the compression type.
+// For per-file formats only the directory of outputPrefix is used.
+func NewArchive(outputPrefix string, settings Settings) (*Archive, error) {
+	f, ok := formats[settings.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression type for streaming: %s", settings.Type)
+	}
+	a := &Archive{
+		dir:      filepath.Dir(outputPrefix),
+		format:   f,
+		settings: settings,
+		ready:    make(map[int]*Entry),
+		done:     make(map[int]string),
+	}
+	if len(settings.Recipients) > 0 {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, err
+		}
+		a.spoolKey = key
+	}
+	if !f.tar && settings.Type != "zip" {
+		logging.Info("Streaming dump output into %s files in %s", f.ext+a.encryptedExt(), a.dir)
+		return a, nil
+	}
+	a.path = outputPrefix + f.ext + a.encryptedExt()
+	a.tmp = a.path + ".tmp"
+	out, err := os.Create(a.tmp)
+	if err != nil {
+		return nil, err
+	}
+	a.out = out
+	a.sink, err = a.encrypt(out)
+	if err == nil && f.tar {
+		a.compressor, err = f.codec.newWriter(a.sink, settings.Level, settings.threads())
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(a.tmp)
+		return nil, err
+	}
+	if f.tar {
+		a.tarw = tar.NewWriter(a.compressor)
+	} else {
+		a.zipw = zip.NewWriter(a.sink)
+	}
+	logging.Info("Streaming dump output into %s", a.path)
+	return a, nil
+}
+
+// Files returns the files produced so far; for tar and zip formats this is the
+// archive itself once Close has been called.
+func (a *Archive) Files() []string {
+	return a.files
+}
+
+// Begin starts a new entry named name, a path relative to the directory of
+// the archive. seq is the position of the entry in the archive, counting
+// from 0; every position must eventually be committed or skipped.
+func (a *Archive) Begin(seq int, name string) (*Entry, error) {
+	e := &Entry{archive: a, seq: seq, name: filepath.ToSlash(name), crc: crc32.NewIEEE()}
+	var err error
+	if a.out == nil {
+		e.path = filepath.Join(a.dir, name+a.format.ext+a.encryptedExt())
+		if err := os.MkdirAll(filepath.Dir(e.path), os.ModePerm); err != nil {
+			return nil, err
+		}
+		e.file, err = os.Create(e.path + ".tmp")
+	} else {
+		e.file, err = os.CreateTemp(a.dir, "."+filepath.Base(name)+".spool-*")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sink, cw io.WriteCloser
+	switch {
+	case a.tarw != nil:
+		if sink, err = a.encryptSpool(e.file); err == nil {
+			cw, err = zstd.NewWriter(sink, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		}
+	case a.zipw != nil:
+		if sink, err = a.encryptSpool(e.file); err == nil {
+			level := a.settings.Level
+			if level == 0 {
+				level = flate.DefaultCompression
+			}
+			cw, err = flate.NewWriter(&byteCounter{w: sink, n: &e.compressed}, level)
+		}
+	default:
+		if sink, err = a.encrypt(e.file); err == nil {
+			cw = nopWriteCloser{sink}
+			if a.format.codec != nil {
+				cw, err = a.format.codec.newWriter(sink, a.settings.Level, a.settings.threads())
+			}
+		}
+	}
+	if err != nil {
+		e.Discard()
+		return nil, err
+	}
+	e.w = cw
+	e.closers = []io.Closer{cw, sink}
+	return e, nil
+}
+
+// Skip marks the position seq as not producing an entry, e.g. because the
+// dump failed, so that later entries do not wait for it.
+func (a *Archive) Skip(seq int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.done[seq] = ""
+	a.drain()
+}
+
+// drain adds the waiting entries whose turn it is. a.mu must be held.
+func (a *Archive) drain() {
+	for {
+		if file, ok := a.done[a.next]; ok {
+			if file != "" {
+				a.files = append(a.files, file)
+			}
+			delete(a.done, a.next)
+			a.next++
+			continue
+		}
+		e, ok := a.ready[a.next]
+		if !ok {
+			return
+		}
+		delete(a.ready, a.next)
+		if a.err == nil {
+			a.err = e.add()
+		}
+		e.Discard()
+		a.next++
+	}
+}
+
+// Close finishes the archive, verifies it and renames it into place. An
+// archive without any committed entry is removed.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.ready) > 0 || len(a.done) > 0 {
+		return fmt.Errorf("archive closed with %d entries out of sequence", len(a.ready)+len(a.done))
+	}
+	if a.out == nil {
+		return nil
+	}
+	err := a.err
+	if a.tarw != nil {
+		if cerr := closeAll(a.tarw, a.compressor, a.sink); err == nil {
+			err = cerr
+		}
+	} else if cerr := closeAll(a.zipw, a.sink); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = a.out.Sync()
+	}
+	if cerr := a.out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || len(a.entries) == 0 {
+		os.Remove(a.tmp)
+		return err
+	}
+	read := readZip
+	if a.tarw != nil {
+		read = readTar(a.format)
+	}
+	if err := finishArchive(a.tmp, a.path, a.entries, a.verifier(read)); err != nil {
+		return err
+	}
+	a.files = append(a.files, a.path)
+	return nil
+}
+
+// verifier returns read, or nil for encrypted output, which cannot be read
+// back.
+func (a *Archive) verifier(read func(*os.File) ([]entryInfo, error)) func(*os.File) ([]entryInfo, error) {
+	if len(a.settings.Recipients) > 0 {
+		return nil
+	}
+	return read
+}
+
+// encryptedExt returns the extra extension of encrypted output.
+func (a *Archive) encryptedExt() string {
+	if len(a.settings.Recipients) > 0 {
+		return encrypt.Ext
+	}
+	return ""
+}
+
+// encrypt wraps w with age encryption to the configured recipients, if any.
+func (a *Archive) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if len(a.settings.Recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, a.settings.Recipients...)
+}
+
+// encryptSpool wraps a spool file with encryption to the in-memory spool key.
+func (a *Archive) encryptSpool(w io.Writer) (io.WriteCloser, error) {
+	if a.spoolKey == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, a.spoolKey.Recipient())
+}
+
+// decryptSpool reverses encryptSpool.
+func (a *Archive) decryptSpool(r io.Reader) (io.Reader, error) {
+	if a.spoolKey == nil {
+		return r, nil
+	}
+	return age.Decrypt(r, a.spoolKey)
+}
+
+// Write appends uncompressed dump output to the entry.
+func (e *Entry) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	e.crc.Write(p[:n])
+	e.size.Add(int64(n))
+	return n, err
+}
+
+// Size returns the number of uncompressed bytes written so far. It is safe to
+// call while another goroutine is writing.
+func (e *Entry) Size() int64 {
+	return e.size.Load()
+}
+
+// Commit finishes the entry. It is added to the archive, and its spool file
+// removed, as soon as all entries before it have been committed or skipped;
+// errors doing so later are returned by Archive.Close.
+func (e *Entry) Commit() error {
+	a := e.archive
+	if err := closeAll(e.closers...); err != nil {
+		e.Discard()
+		return err
+	}
+	if a.out == nil {
+		// Per-file format: the entry becomes the final file
+		err := e.file.Sync()
+		if cerr := e.file.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			want := []entryInfo{e.info(path.Base(e.name))}
+			err = finishArchive(e.file.Name(), e.path, want, a.verifier(readFile(a.format, path.Base(e.name))))
+		}
+		if err != nil {
+			e.Discard()
+			return err
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.done[e.seq] = e.path
+		e.file = nil
+		a.drain()
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ready[e.seq] = e
+	a.drain()
+	return nil
+}
+
+// add copies the spooled entry into the archive. a.mu must be held.
+func (e *Entry) add() error {
+	a := e.archive
+	if _, err := e.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	spool, err := a.decryptSpool(e.file)
+	if err != nil {
+		return err
+	}
+	if a.zipw != nil {
+		return e.commitZip(spool)
+	}
+	zr, err := zstd.NewReader(spool)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     e.name,
+		Size:     e.Size(),
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	if err := a.tarw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err = io.Copy(a.tarw, zr); err != nil {
+		return err
+	}
+	a.entries = append(a.entries, e.info(e.name))
+	return nil
+}
+
+// info returns what reading the entry back as name must yield.
+func (e *Entry) info(name string) entryInfo {
+	return entryInfo{name, e.Size(), e.crc.Sum32()}
+}
+
+// commitZip copies the spooled deflate data into the zip archive as is.
+func (e *Entry) commitZip(spool io.Reader) error {
+	fh := &zip.FileHeader{
+		Name:               e.name,
+		Method:             zip.Deflate,
+		CRC32:              e.crc.Sum32(),
+		CompressedSize64:   uint64(e.compressed),
+		UncompressedSize64: uint64(e.Size()),
+	}
+	// CreateRaw does not derive the MS-DOS date fields from Modified
+	fh.SetModTime(time.Now())
+	w, err := e.archive.zipw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, spool); err != nil {
+		return err
+	}
+	e.archive.entries = append(e.archive.entries, e.info(e.name))
+	return nil
+}
+
+// Discard drops the entry and removes its spool or output file.
+func (e *Entry) Discard() {
+	if e.file == nil {
+		return
+	}
+	e.file.Close()
+	os.Remove(e.file.Name())
+	e.file = nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// byteCounter counts the bytes written through it into n.
+type byteCounter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// closeAll closes every closer in order and returns the first error.
+func closeAll(closers ...io.Closer) error {
+	var err error
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -24,6 +24,8 @@
 package config
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -38,42 +40,163 @@ func (d *CommaSeparatedList) UnmarshalFlag(value string) error {
 	return nil
 }
 
+// PromptPassword is the value of Password when -p is given without a value,
+// which asks for the password on the terminal.
+const PromptPassword = "\x00"
+
+// DefaultDefaultsFile is the default of --defaults-file. The clients read it
+// on their own, so it is not passed to them.
+const DefaultDefaultsFile = "~/.my.cnf"
+
+// ConnectionOptions holds the flags used to connect to the MySQL server.
+// They are shared by every mode (dump, restore).
+type ConnectionOptions struct {
+	User                string `short:"u" long:"user" description:"MySQL username" value-name:"USER"`
+	Password            string `short:"p" long:"password" optional:"yes" optional-value:"\x00" description:"MySQL password; without a value (-p) it is asked for on the terminal" value-name:"PASSWORD"`
+	PasswordFile        string `long:"password-file" description:"Read the MySQL password from the first line of FILE" value-name:"FILE"`
+	PasswordEnv         string `long:"password-env" description:"Read the MySQL password from the environment variable NAME" value-name:"NAME"`
+	PasswordCommand     string `long:"password-command" description:"Run COMMAND with the shell and use its output as the MySQL password" value-name:"COMMAND"`
+	Host                string `short:"h" long:"host" description:"MySQL host address" value-name:"HOST"`
+	Port                string `short:"P" long:"port" description:"MySQL port" value-name:"PORT"`
+	Socket              string `short:"s" long:"socket" description:"Path to MySQL socket" value-name:"SOCKET"`
+	DefaultsFile        string `long:"defaults-file" default:"~/.my.cnf" description:"Path to MySQL defaults file" value-name:"FILE"`
+	DefaultsGroupSuffix string `long:"defaults-group-suffix" description:"Suffix to append to the default group name in the MySQL configuration file"`
+}
+
+// LogOptions holds the flags selecting the format and the destination of
+// log messages. They are shared by every mode.
+type LogOptions struct {
+	LogFormat   string `long:"log-format" default:"text" description:"Format of log messages: text, or json with one object per line (timestamp, level, msg, run_id, job, database, attempt, phase)" choice:"text" choice:"json"`
+	LogFile     string `long:"log-file" description:"Write log messages to FILE instead of stderr" value-name:"FILE"`
+	LogMaxSize  int    `long:"log-max-size" default:"100" description:"Rotate --log-file when it reaches SIZE MiB; 0 never rotates" value-name:"MIB"`
+	LogMaxFiles int    `long:"log-max-files" default:"5" description:"Number of rotated log files kept (FILE.1 is the newest)" value-name:"N"`
+	Syslog      bool   `long:"syslog" description:"Send log messages to the local syslog daemon instead of stderr"`
+	Journald    bool   `long:"journald" description:"Send log messages to the systemd journal, with the fields of the json format as journal fields"`
+}
+
 type Options struct {
-	User                string             `short:"u" long:"user" description:"MySQL username" value-name:"USER"`
-	Password            string             `short:"p" long:"password" description:"MySQL password" value-name:"PASSWORD"`
-	Host                string             `short:"h" long:"host" description:"MySQL host address" value-name:"HOST"`
-	Port                string             `short:"P" long:"port" description:"MySQL port" value-name:"PORT"`
-	Socket              string             `short:"s" long:"socket" description:"Path to MySQL socket" value-name:"SOCKET"`
-	DefaultsFile        string             `long:"defaults-file" default:"~/.my.cnf" description:"Path to MySQL defaults file" value-name:"FILE"`
-	DefaultsGroupSuffix string             `long:"defaults-group-suffix" description:"Suffix to append to the default group name in the MySQL configuration file"`
-	AllDatabases        bool               `long:"all-databases" description:"Dump all databases"`
-	Databases           CommaSeparatedList `long:"databases" description:"Comma-separated list of databases to dump. Supports glob patterns (* and ?) per entry." value-name:"DATABASE1,DATABASE2"`
-	SeparateDumps       bool               `long:"separate-dumps" description:"Create separate dump files for each database provided with --databases"`
-	ExcludeTables       CommaSeparatedList `long:"exclude" description:"Comma-separated list of tables to exclude. Supports glob patterns (* and ?)." value-name:"DB1.TABLE1,DB2.TABLE2"`
-	ExcludeTablesData   CommaSeparatedList `long:"exclude-data" description:"Comma-separated list of tables to exclude data from (but keep the schema). Supports glob patterns (* and ?)." value-name:"DB1.TABLE1,DB2.TABLE2"`
-	OutputPath          string             `long:"output" default:"./" description:"Output file path" value-name:"PATH"`
-	Compression         string             `long:"compression" default:"none" description:"Compression type (tgz, tbz2, zip, none)" choice:"tgz" choice:"tbz2" choice:"zip" choice:"none"`
-	DryRun              bool               `long:"dry-run" description:"Simulate the dump process"`
-	RemoveDefiners      bool               `long:"remove-definers" description:"Remove definer statements"`
-	Retries             int                `long:"retries" default:"3" description:"Number of retries on failure" value-name:"NUM_RETRIES"`
-	RetryInterval       int                `long:"retry-interval" default:"30" description:"Seconds between retries" value-name:"SECONDS"`
-	NotifyEmail         string             `long:"notify" description:"Email to send notifications" value-name:"EMAIL_ADDRESS"`
-	Silent              bool               `short:"q" long:"silent" description:"Only print errors to stderr"`
-	Verbose             bool               `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
-	ShowVersion         bool               `long:"version" description:"Show version and exit"`
+	ConnectionOptions
+	AllDatabases       bool               `long:"all-databases" description:"Dump all databases"`
+	Databases          CommaSeparatedList `long:"databases" description:"Comma-separated list of databases to dump. Supports glob patterns (* and ?) per entry." value-name:"DATABASE1,DATABASE2"`
+	SeparateDumps      bool               `long:"separate-dumps" description:"Create separate dump files for each database provided with --databases"`
+	Engine             string             `long:"engine" default:"mysqldump" description:"Dump engine: mysqldump (runs mysqldump or mariadb-dump) or native (built-in, over the MySQL protocol)" choice:"mysqldump" choice:"native"`
+	Layout             string             `long:"layout" default:"database" description:"Dump layout: database (one file per dump) or per-table (<db>/<table>_schema.sql and <db>/<table>_data.sql, plus routines, views and triggers files)" choice:"database" choice:"per-table"`
+	ConsistentSnapshot bool               `long:"consistent-snapshot" description:"Make all dumps see the same data. With --engine=native a global read lock is only held while the dump transactions start; with mysqldump it is held, blocking all writes, until the last dump finishes"`
+	ExcludeTables      CommaSeparatedList `long:"exclude" description:"Comma-separated list of tables to exclude. Supports glob patterns (* and ?)." value-name:"DB1.TABLE1,DB2.TABLE2"`
+	ExcludeTablesData  CommaSeparatedList `long:"exclude-data" description:"Comma-separated list of tables to exclude data from (but keep the schema). Supports glob patterns (* and ?)." value-name:"DB1.TABLE1,DB2.TABLE2"`
+	OutputPath         string             `long:"output" default:"./" description:"Output file path" value-name:"PATH"`
+	Timestamped        bool               `long:"timestamped" description:"Write each run into a timestamped subdirectory of --output"`
+	KeepLast           int                `long:"keep-last" description:"Keep the N most recent runs (implies --timestamped)" value-name:"N"`
+	KeepDaily          int                `long:"keep-daily" description:"Keep the newest run of each of the last N days (implies --timestamped)" value-name:"N"`
+	KeepWeekly         int                `long:"keep-weekly" description:"Keep the newest run of each of the last N weeks (implies --timestamped)" value-name:"N"`
+	KeepMonthly        int                `long:"keep-monthly" description:"Keep the newest run of each of the last N months (implies --timestamped)" value-name:"N"`
+	Compression        string             `long:"compression" default:"none" description:"Compression type: tar archives (tgz, tbz2, tzst, txz, tlz4), zip, per-file (gz, zst) or none" choice:"tgz" choice:"tbz2" choice:"tzst" choice:"txz" choice:"tlz4" choice:"zip" choice:"gz" choice:"zst" choice:"none"`
+	CompressionLevel   int                `long:"compression-level" default:"0" description:"Compression level (gzip, bzip2, xz, lz4, zip: 1-9, zstd: 1-22); 0 uses the default of each format" value-name:"LEVEL"`
+	CompressionThreads int                `long:"compression-threads" default:"1" description:"Number of threads used by gzip, zstd and lz4 compression; 0 uses one per CPU" value-name:"NUM"`
+	Stream             bool               `long:"stream" description:"Compress mysqldump output while it is being dumped instead of afterwards (requires --compression other than none or --encrypt)"`
+	Encrypt            bool               `long:"encrypt" description:"Encrypt the output with age (implies --stream)"`
+	EncryptRecipients  []string           `long:"recipient" description:"age recipient (age1...) to encrypt to; can be repeated" value-name:"RECIPIENT"`
+	RecipientsFile     string             `long:"recipients-file" description:"File with age recipients to encrypt to, one per line" value-name:"FILE"`
+	PassphraseFile     string             `long:"passphrase-file" description:"Encrypt with the passphrase read from FILE instead of recipients" value-name:"FILE"`
+	Upload             []string           `long:"upload" description:"Upload the finished files to a destination (s3://bucket/prefix or sftp://user@host/path); can be repeated" value-name:"URL"`
+	S3Endpoint         string             `long:"s3-endpoint" default:"s3.amazonaws.com" description:"S3 endpoint host[:port]" value-name:"HOST"`
+	S3Region           string             `long:"s3-region" description:"S3 region" value-name:"REGION"`
+	S3PathStyle        bool               `long:"s3-path-style" description:"Use path-style bucket addressing (e.g. for MinIO)"`
+	S3StorageClass     string             `long:"s3-storage-class" description:"Storage class of uploaded objects (e.g. STANDARD_IA)" value-name:"CLASS"`
+	S3PartSize         int                `long:"s3-part-size" default:"64" description:"Multipart upload part size in MiB" value-name:"MIB"`
+	S3DisableTLS       bool               `long:"s3-disable-tls" description:"Connect to the S3 endpoint over plain HTTP"`
+	SFTPIdentities     []string           `long:"sftp-identity" description:"SSH private key for sftp uploads (default: ~/.ssh/id_ed25519, id_ecdsa, id_rsa); can be repeated" value-name:"FILE"`
+	SFTPKnownHosts     string             `long:"sftp-known-hosts" default:"~/.ssh/known_hosts" description:"known_hosts file used to verify sftp servers" value-name:"FILE"`
+	DeleteAfterUpload  bool               `long:"delete-after-upload" description:"Delete local files once every upload destination has them"`
+	NoManifest         bool               `long:"no-manifest" description:"Do not write MANIFEST.json with the checksums of the produced files"`
+	SHA256Sums         bool               `long:"sha256sums" description:"Also write a SHA256SUMS file that sha256sum -c can check"`
+	DryRun             bool               `long:"dry-run" description:"Simulate the dump process"`
+	RemoveDefiners     bool               `long:"remove-definers" description:"Remove definer statements"`
+	Retries            int                `long:"retries" default:"3" description:"Number of retries on failure" value-name:"NUM_RETRIES"`
+	RetryInterval      int                `long:"retry-interval" default:"30" description:"Seconds between retries" value-name:"SECONDS"`
+	Jobs               int                `long:"jobs" default:"1" description:"Number of --separate-dumps or --layout=per-table dumps to run at the same time" value-name:"N"`
+	NotifyEmail        CommaSeparatedList `long:"notify" description:"Comma-separated list of email addresses to send a summary of the run to" value-name:"EMAIL_ADDRESS"`
+	NotifyOnFailure    bool               `long:"notify-on-failure" description:"Only send notifications when the backup failed"`
+	Webhooks           []string           `long:"webhook" description:"POST a JSON report of the run to URL; can be repeated" value-name:"URL"`
+	WebhookSecret      string             `long:"webhook-secret" env:"MYMAGICDUMP_WEBHOOK_SECRET" description:"Sign webhook payloads with HMAC-SHA256 (or set MYMAGICDUMP_WEBHOOK_SECRET)" value-name:"SECRET"`
+	WebhookTemplate    string             `long:"webhook-template" default:"json" description:"Webhook payload: json, slack or a Go text/template file executed with the report" value-name:"json|slack|FILE"`
+	MetricsTextfileDir string             `long:"metrics-textfile-dir" description:"Write Prometheus metrics of the run to mymagicdump_<job>.prom in DIR, for the node_exporter textfile collector" value-name:"DIR"`
+	MetricsJob         string             `long:"metrics-job" description:"Value of the job label of the metrics (default: the name of the job in the jobs file, or mymagicdump)" value-name:"NAME"`
+	SMTPHost           string             `long:"smtp-host" default:"localhost" description:"SMTP server used for --notify" value-name:"HOST"`
+	SMTPPort           int                `long:"smtp-port" description:"SMTP port (default: 465 with --smtp-tls=tls, 25 otherwise)" value-name:"PORT"`
+	SMTPTLS            string             `long:"smtp-tls" default:"auto" description:"SMTP encryption: auto (STARTTLS when offered), starttls (required), tls (implicit TLS) or none" choice:"auto" choice:"starttls" choice:"tls" choice:"none"`
+	SMTPUser           string             `long:"smtp-user" description:"SMTP username" value-name:"USER"`
+	SMTPPassword       string             `long:"smtp-password" env:"MYMAGICDUMP_SMTP_PASSWORD" description:"SMTP password (or set MYMAGICDUMP_SMTP_PASSWORD)" value-name:"PASSWORD"`
+	SMTPFrom           string             `long:"smtp-from" description:"Sender address (default: mymagicdump@<hostname>)" value-name:"EMAIL_ADDRESS"`
+	Events             string             `long:"events" default:"none" description:"Write progress events to stdout (or --progress-fd): none, or json with one object per line" choice:"none" choice:"json"`
+	ProgressFD         int                `long:"progress-fd" description:"Write the --events to file descriptor N instead of stdout (implies --events=json)" value-name:"N"`
+	Silent             bool               `short:"q" long:"silent" description:"Only print errors to stderr"`
+	Verbose            bool               `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
+	ShowVersion        bool               `long:"version" description:"Show version and exit"`
+	LogOptions
 	// Passthrough holds any flags/args not recognized by our parser that should be forwarded to mysqldump
 	Passthrough []string `no-flag:"true"`
+	// Job is the name of the jobs file job the options belong to, if any
+	Job string `no-flag:"true"`
+}
+
+// EventsToStdout reports whether the --events stream takes stdout, which
+// then gets no other output.
+func (o *Options) EventsToStdout() bool {
+	return (o.Events == "json" && o.ProgressFD == 0) || o.ProgressFD == 1
+}
+
+// EventOutput returns where the --events stream is written: stdout, the
+// file descriptor of --progress-fd, or nil without events.
+func (o *Options) EventOutput() (io.Writer, error) {
+	if o.ProgressFD == 0 && o.Events != "json" {
+		return nil, nil
+	}
+	switch fd := o.ProgressFD; {
+	case fd < 0:
+		return nil, fmt.Errorf("invalid --progress-fd %d", fd)
+	case fd <= 1:
+		return os.Stdout, nil
+	default:
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+		if f == nil {
+			return nil, fmt.Errorf("invalid --progress-fd %d", fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("--progress-fd %d is not open: %w", fd, err)
+		}
+		return f, nil
+	}
 }
 
 func ParseArgs() (*Options, error) {
-	var opts Options
-	// Ignore unknown flags so we can forward them to mysqldump
-	parser := flags.NewParser(&opts, flags.Default|flags.IgnoreUnknown)
+	return parseOptions(os.Args[1:], flags.Default)
+}
+
+// newParser returns the parser of the dump options. Unknown flags are
+// ignored so that they can be forwarded to mysqldump.
+func newParser(opts *Options, options flags.Options) *flags.Parser {
+	parser := flags.NewParser(opts, options|flags.IgnoreUnknown)
 	parser.Name = "mymagicdump"
 	parser.ShortDescription = "TrustServers MySQL backup tool using mysqldump with exclusions, retries and compression."
-	parser.LongDescription = "A fast, scriptable MySQL backup tool built on mysqldump. Supports multiple databases, table/data exclusions, compression (tgz/zip), retries, and optional DEFINER removal."
+	parser.LongDescription = "A fast, scriptable MySQL backup tool built on mysqldump. Supports multiple databases, table/data exclusions, compression (tar.gz/bz2/zst/xz/lz4, zip, gz, zst), retries, and optional DEFINER removal."
+	return parser
+}
+
+// DefaultOptions returns the dump options with the default value of every
+// flag.
+func DefaultOptions() *Options {
+	var opts Options
+	// Without arguments only the defaults are applied, which cannot fail
+	newParser(&opts, flags.None).ParseArgs(nil)
+	return &opts
+}
+
+func parseOptions(args []string, options flags.Options) (*Options, error) {
+	var opts Options
+	parser := newParser(&opts, options)
 	// Parse and capture leftover args (unknown flags/positional)
-	rest, err := parser.Parse()
+	rest, err := parser.ParseArgs(args)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +217,87 @@ func ParseArgs() (*Options, error) {
 	return &opts, nil
 }
 
+// RestoreOptions holds the flags for the restore subcommand.
+type RestoreOptions struct {
+	ConnectionOptions
+	LogOptions
+	Database       string   `short:"D" long:"database" description:"Restore a single-database dump into DATABASE instead of the database named by the file" value-name:"DATABASE"`
+	Force          bool     `long:"force" description:"Continue restoring even if an SQL error occurs"`
+	Identities     []string `short:"i" long:"identity" description:"age identity file used to decrypt .age files; can be repeated" value-name:"FILE"`
+	PassphraseFile string   `long:"passphrase-file" description:"Decrypt .age files with the passphrase read from FILE" value-name:"FILE"`
+	DryRun         bool     `long:"dry-run" description:"List what would be restored without executing anything"`
+	Silent         bool     `short:"q" long:"silent" description:"Only print errors to stderr"`
+	Verbose        bool     `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
+	Args           struct {
+		Files []string `positional-arg-name:"FILE|DIR" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ParseRestoreArgs parses the arguments following the restore subcommand.
+func ParseRestoreArgs(args []string) (*RestoreOptions, error) {
+	var opts RestoreOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mymagicdump restore"
+	parser.ShortDescription = "Restore mymagicdump output into a MySQL server."
+	parser.LongDescription = "Replays plain .sql files or archives produced by mymagicdump (tar.gz, tar.bz2, tar.zst, tar.xz, tar.lz4, zip, .gz, .zst) into a MySQL/MariaDB server using the mysql client. A directory holding a --layout=per-table dump is restored in the order of its RESTORE_ORDER.json."
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// ExtractOptions holds the flags for the extract subcommand.
+type ExtractOptions struct {
+	LogOptions
+	OutputPath     string   `long:"output" default:"./" description:"Directory to extract into" value-name:"PATH"`
+	Identities     []string `short:"i" long:"identity" description:"age identity file used to decrypt .age files; can be repeated" value-name:"FILE"`
+	PassphraseFile string   `long:"passphrase-file" description:"Decrypt .age files with the passphrase read from FILE" value-name:"FILE"`
+	Silent         bool     `short:"q" long:"silent" description:"Only print errors to stderr"`
+	Verbose        bool     `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
+	Args           struct {
+		Files []string `positional-arg-name:"FILE" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ParseExtractArgs parses the arguments following the extract subcommand.
+func ParseExtractArgs(args []string) (*ExtractOptions, error) {
+	var opts ExtractOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mymagicdump extract"
+	parser.ShortDescription = "Decrypt and unpack mymagicdump output."
+	parser.LongDescription = "Writes the .sql files contained in archives or encrypted files produced by mymagicdump into a directory."
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// VerifyOptions holds the flags for the verify subcommand.
+type VerifyOptions struct {
+	LogOptions
+	Deep           bool     `long:"deep" description:"Also decompress (and decrypt) every file of a directory to check its contents"`
+	Identities     []string `short:"i" long:"identity" description:"age identity file used to decrypt .age files; can be repeated" value-name:"FILE"`
+	PassphraseFile string   `long:"passphrase-file" description:"Decrypt .age files with the passphrase read from FILE" value-name:"FILE"`
+	Silent         bool     `short:"q" long:"silent" description:"Only print errors to stderr"`
+	Verbose        bool     `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
+	Args           struct {
+		Paths []string `positional-arg-name:"DIR|FILE" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ParseVerifyArgs parses the arguments following the verify subcommand.
+func ParseVerifyArgs(args []string) (*VerifyOptions, error) {
+	var opts VerifyOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mymagicdump verify"
+	parser.ShortDescription = "Check mymagicdump output for corruption."
+	parser.LongDescription = "Re-hashes the files listed in a run's MANIFEST.json (or SHA256SUMS) and reports missing or corrupted files. Files given directly are also read through completely to check that they decompress (and decrypt) cleanly."
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
 // Expands a leading ~ to the user's home directory
 func ExpandTilde(p string) string {
 	if p == "~" {
@@ -0,0 +1,490 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys of a job that are not command line options: the flags forwarded to
+// mysqldump, and when and with how much random delay the daemon runs it.
+const (
+	passthroughKey = "mysqldump-flags"
+	scheduleKey    = "schedule"
+	jitterKey      = "jitter"
+)
+
+// JobsFile is a --config file. It holds named jobs, each a set of dump
+// options keyed by their long flag name, and defaults shared by all jobs:
+//
+//	defaults:
+//	  user: backup
+//	  compression: tzst
+//	jobs:
+//	  shop:
+//	    schedule: "0 2 * * *"
+//	    host: db1.example.com
+//	    databases: [shop_*]
+//	    exclude: [shop_main.sessions]
+type JobsFile struct {
+	Path     string
+	defaults []jobValue
+	jobs     []job
+}
+
+type job struct {
+	name   string
+	line   int
+	values []jobValue
+}
+
+// jobValue is the value of one option of a job, or of the defaults when
+// defaults is set.
+type jobValue struct {
+	key      string
+	node     *yaml.Node
+	defaults bool
+}
+
+// LoadJobs reads a jobs file. Only the structure of the file is checked;
+// Validate checks the options of its jobs.
+func LoadJobs(path string) (*JobsFile, error) {
+	data, err := os.ReadFile(ExpandTilde(path))
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f := &JobsFile{Path: path}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: no jobs defined", path)
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping with defaults and jobs", path, root.Line)
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "defaults":
+			if f.defaults, err = f.values(value); err != nil {
+				return nil, err
+			}
+			for i := range f.defaults {
+				f.defaults[i].defaults = true
+			}
+		case "jobs":
+			if value.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s:%d: jobs must map job names to their options", path, value.Line)
+			}
+			for j := 0; j < len(value.Content); j += 2 {
+				name := value.Content[j]
+				if f.job(name.Value) != nil {
+					return nil, fmt.Errorf("%s:%d: job %q is defined twice", path, name.Line, name.Value)
+				}
+				values, err := f.values(value.Content[j+1])
+				if err != nil {
+					return nil, err
+				}
+				f.jobs = append(f.jobs, job{name: name.Value, line: name.Line, values: values})
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q, expected defaults or jobs", path, key.Line, key.Value)
+		}
+	}
+	if len(f.jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs defined", path)
+	}
+	return f, nil
+}
+
+// values reads the options of a job or of the defaults.
+func (f *JobsFile) values(node *yaml.Node) ([]jobValue, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of options", f.Path, node.Line)
+	}
+	var values []jobValue
+	for i := 0; i < len(node.Content); i += 2 {
+		values = append(values, jobValue{key: node.Content[i].Value, node: node.Content[i+1]})
+	}
+	return values, nil
+}
+
+func (f *JobsFile) job(name string) *job {
+	for i := range f.jobs {
+		if f.jobs[i].name == name {
+			return &f.jobs[i]
+		}
+	}
+	return nil
+}
+
+// value returns the value of key for a job, from the defaults when the job
+// does not set it, or nil.
+func (f *JobsFile) value(j *job, key string) *yaml.Node {
+	for _, values := range [][]jobValue{j.values, f.defaults} {
+		for _, v := range values {
+			if v.key == key {
+				return v.node
+			}
+		}
+	}
+	return nil
+}
+
+// JobSchedule is when the daemon runs a job.
+type JobSchedule struct {
+	// Spec is the cron expression, e.g. "0 2 * * *" or "@daily"
+	Spec     string
+	Schedule cron.Schedule
+	// Jitter is the maximum random delay before each run
+	Jitter time.Duration
+}
+
+// Schedule returns the schedule of a job, or nil when it has none.
+func (f *JobsFile) Schedule(name string) (*JobSchedule, error) {
+	j := f.job(name)
+	if j == nil {
+		return nil, fmt.Errorf("%s: no job named %q", f.Path, name)
+	}
+	spec := f.value(j, scheduleKey)
+	if spec == nil {
+		return nil, nil
+	}
+	if spec.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("%s:%d: job %q: schedule: expected a cron expression", f.Path, spec.Line, name)
+	}
+	schedule, err := cron.ParseStandard(spec.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%d: job %q: invalid schedule %q: %w", f.Path, spec.Line, name, spec.Value, err)
+	}
+	js := &JobSchedule{Spec: spec.Value, Schedule: schedule}
+	if jitter := f.value(j, jitterKey); jitter != nil {
+		if js.Jitter, err = time.ParseDuration(jitter.Value); err != nil || js.Jitter < 0 {
+			return nil, fmt.Errorf("%s:%d: job %q: invalid jitter %q: expected a duration such as 10m", f.Path, jitter.Line, name, jitter.Value)
+		}
+	}
+	return js, nil
+}
+
+// Names returns the names of the jobs in the order of the file.
+func (f *JobsFile) Names() []string {
+	names := make([]string, len(f.jobs))
+	for i, j := range f.jobs {
+		names[i] = j.name
+	}
+	return names
+}
+
+// Options returns the options of a job: the defaults, overridden by the
+// options of the job, overridden by the command line flags in overrides.
+func (f *JobsFile) Options(name string, overrides []string) (*Options, error) {
+	j := f.job(name)
+	if j == nil {
+		return nil, fmt.Errorf("%s: no job named %q", f.Path, name)
+	}
+	args, errs := f.args(j, overrides)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	opts, err := parseOptions(args, flags.HelpFlag|flags.PassDoubleDash)
+	if err != nil {
+		return nil, fmt.Errorf("%s: job %q: %w", f.Path, name, err)
+	}
+	opts.Job = name
+	return opts, nil
+}
+
+// Validate checks every job for unknown keys, invalid values and invalid
+// database and table patterns, and returns all problems found.
+func (f *JobsFile) Validate() []error {
+	var problems []error
+	seen := map[string]bool{}
+	for i := range f.jobs {
+		j := &f.jobs[i]
+		args, errs := f.args(j, nil)
+		// A problem of the defaults is reported once, not for every job
+		for _, err := range errs {
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				problems = append(problems, err)
+			}
+		}
+		if _, err := f.Schedule(j.name); err != nil {
+			problems = append(problems, err)
+		}
+		opts, err := parseOptions(args, flags.PassDoubleDash)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s:%d: job %q: %w", f.Path, j.line, j.name, err))
+			continue
+		}
+		for _, err := range checkPatterns(opts) {
+			problems = append(problems, fmt.Errorf("%s:%d: job %q: %w", f.Path, j.line, j.name, err))
+		}
+	}
+	return problems
+}
+
+// args converts the options of a job into command line flags. Options set in
+// overrides are left out, so that the flags of the command line replace them
+// rather than add to them.
+func (f *JobsFile) args(j *job, overrides []string) ([]string, []error) {
+	var opts Options
+	parser := newParser(&opts, 0)
+	overridden := overriddenOptions(parser, overrides)
+	values := slices.DeleteFunc(slices.Clone(f.defaults), func(d jobValue) bool {
+		return slices.ContainsFunc(j.values, func(v jobValue) bool { return v.key == d.key })
+	})
+	values = append(values, j.values...)
+
+	var args, pass []string
+	var errs []error
+	for _, v := range values {
+		if slices.Contains(overridden, v.key) {
+			continue
+		}
+		a, p, err := optionArgs(parser, v)
+		if err != nil {
+			scope := fmt.Sprintf("job %q", j.name)
+			if v.defaults {
+				scope = "defaults"
+			}
+			errs = append(errs, fmt.Errorf("%s:%d: %s: %w", f.Path, v.node.Line, scope, err))
+			continue
+		}
+		args, pass = append(args, a...), append(pass, p...)
+	}
+	args = append(args, overrides...)
+	return append(args, pass...), errs
+}
+
+// optionArgs returns the flags for one option of a job, or the flags to
+// forward to mysqldump for mysqldump-flags.
+func optionArgs(parser *flags.Parser, v jobValue) (args, pass []string, err error) {
+	switch v.key {
+	case passthroughKey:
+		pass, err = scalars(v.node)
+		return nil, pass, err
+	case scheduleKey, jitterKey:
+		return nil, nil, nil
+	}
+	opt := parser.FindOptionByLongName(v.key)
+	if opt == nil || v.key == "version" {
+		return nil, nil, fmt.Errorf("unknown key %q", v.key)
+	}
+	flag := "--" + v.key
+	switch opt.Value().(type) {
+	case bool:
+		var on bool
+		if err := v.node.Decode(&on); err != nil {
+			return nil, nil, fmt.Errorf("%s: expected true or false", v.key)
+		}
+		if on {
+			args = append(args, flag)
+		}
+	case CommaSeparatedList:
+		items, err := scalars(v.node)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", v.key, err)
+		}
+		args = append(args, flag+"="+strings.Join(items, ","))
+	case []string:
+		items, err := scalars(v.node)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", v.key, err)
+		}
+		for _, item := range items {
+			args = append(args, flag+"="+item)
+		}
+	default:
+		if v.node.Kind != yaml.ScalarNode {
+			return nil, nil, fmt.Errorf("%s: expected a single value", v.key)
+		}
+		args = append(args, flag+"="+v.node.Value)
+	}
+	return args, nil, nil
+}
+
+// scalars returns the items of a list, or a single value as a list.
+func scalars(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		items := make([]string, len(node.Content))
+		for i, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("expected a list of values")
+			}
+			items[i] = item.Value
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("expected a value or a list of values")
+}
+
+// overriddenOptions returns the long names of the options set by flags.
+func overriddenOptions(parser *flags.Parser, args []string) []string {
+	var names []string
+	for _, arg := range args {
+		var opt *flags.Option
+		switch {
+		case arg == "--" || !strings.HasPrefix(arg, "-"):
+			continue
+		case strings.HasPrefix(arg, "--"):
+			name, _, _ := strings.Cut(arg[2:], "=")
+			opt = parser.FindOptionByLongName(name)
+		case len(arg) > 1:
+			opt = parser.FindOptionByShortName(rune(arg[1]))
+		}
+		if opt != nil {
+			names = append(names, opt.LongName)
+		}
+	}
+	return names
+}
+
+// checkPatterns checks the database and table patterns of a job.
+func checkPatterns(opts *Options) []error {
+	var errs []error
+	if !opts.AllDatabases && len(opts.Databases) == 0 {
+		errs = append(errs, errors.New("no databases selected: set databases or all-databases"))
+	}
+	for _, db := range opts.Databases {
+		if strings.TrimSpace(db) == "" {
+			errs = append(errs, fmt.Errorf("empty entry in databases %q", strings.Join(opts.Databases, ",")))
+		}
+	}
+	for _, list := range []struct {
+		key      string
+		patterns []string
+	}{{"exclude", opts.ExcludeTables}, {"exclude-data", opts.ExcludeTablesData}} {
+		for _, p := range list.patterns {
+			db, table, ok := strings.Cut(p, ".")
+			if !ok || db == "" || table == "" || strings.Contains(table, ".") {
+				errs = append(errs, fmt.Errorf("invalid %s pattern %q: expected database.table", list.key, p))
+			}
+		}
+	}
+	return errs
+}
+
+// RunOptions holds the flags for the run subcommand.
+type RunOptions struct {
+	Config string `long:"config" env:"MYMAGICDUMP_CONFIG" default:"/etc/mymagicdump/jobs.yaml" description:"Jobs file (or set MYMAGICDUMP_CONFIG)" value-name:"FILE"`
+	All    bool   `long:"all" description:"Run every job of the jobs file, in order"`
+	// Jobs are the names of the jobs to run
+	Jobs []string `no-flag:"true"`
+	// Overrides are dump flags that replace the options of the jobs
+	Overrides []string `no-flag:"true"`
+}
+
+// ParseRunArgs parses the arguments following the run subcommand: job names
+// followed by dump flags that override the options of the jobs.
+func ParseRunArgs(args []string) (*RunOptions, error) {
+	var opts RunOptions
+	parser := flags.NewParser(&opts, flags.Default|flags.IgnoreUnknown)
+	parser.Name = "mymagicdump run"
+	parser.Usage = "[--config FILE] (--all | JOB...) [OPTIONS]"
+	parser.ShortDescription = "Run jobs of a jobs file."
+	parser.LongDescription = "Runs the named jobs of a jobs file, or all of them with --all, one after the other. Dump flags following the job names override the options of the jobs."
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	for i < len(rest) && !strings.HasPrefix(rest[i], "-") {
+		i++
+	}
+	opts.Jobs, opts.Overrides = rest[:i], rest[i:]
+	switch {
+	case opts.All && len(opts.Jobs) > 0:
+		err = errors.New("give either --all or job names, not both")
+	case !opts.All && len(opts.Jobs) == 0:
+		err = errors.New("give the jobs to run or --all")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// ConfigOptions holds the flags for the config validate subcommand.
+type ConfigOptions struct {
+	Config string `long:"config" env:"MYMAGICDUMP_CONFIG" default:"/etc/mymagicdump/jobs.yaml" description:"Jobs file (or set MYMAGICDUMP_CONFIG)" value-name:"FILE"`
+	Args   struct {
+		Command string `positional-arg-name:"validate" choice:"validate"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ParseConfigArgs parses the arguments following the config subcommand.
+func ParseConfigArgs(args []string) (*ConfigOptions, error) {
+	var opts ConfigOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mymagicdump config"
+	parser.ShortDescription = "Check a jobs file."
+	parser.LongDescription = "validate reports unknown keys, invalid values and invalid database and table patterns in every job of a jobs file."
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// DaemonOptions holds the flags for the daemon subcommand.
+type DaemonOptions struct {
+	Config        string `long:"config" env:"MYMAGICDUMP_CONFIG" default:"/etc/mymagicdump/jobs.yaml" description:"Jobs file (or set MYMAGICDUMP_CONFIG)" value-name:"FILE"`
+	StatusListen  string `long:"status-listen" default:"127.0.0.1:9310" description:"Address of the HTTP status endpoint; empty to disable" value-name:"HOST:PORT"`
+	MetricsListen string `long:"metrics-listen" description:"Serve Prometheus metrics of the jobs on /metrics at this address; may be the --status-listen address" value-name:"HOST:PORT"`
+	Silent        bool   `short:"q" long:"silent" description:"Only print errors to stderr"`
+	Verbose       bool   `short:"v" long:"verbose" description:"Enable verbose (debug) logging"`
+	LogOptions
+}
+
+// ParseDaemonArgs parses the arguments following the daemon subcommand.
+func ParseDaemonArgs(args []string) (*DaemonOptions, error) {
+	var opts DaemonOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mymagicdump daemon"
+	parser.ShortDescription = "Run the jobs of a jobs file on their schedules."
+	parser.LongDescription = "Runs every job of a jobs file that has a schedule, at the times given by its cron expression, and serves the result of the last run of each job over HTTP."
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
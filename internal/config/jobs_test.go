@@ -0,0 +1,126 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testJobs = `defaults:
+  user: backup
+  compression: tzst
+  retries: 2
+jobs:
+  shop:
+    schedule: "0 2 * * *"
+    host: db1.example.com
+    databases: [shop_*, billing]
+    exclude: [shop_main.sessions]
+    compression: zip
+    mysqldump-flags: [--single-transaction, --where, "id > 10"]
+  blog:
+    databases: blog
+    separate-dumps: true
+  broken:
+    databases: blog
+    no-such-option: 1
+`
+
+func TestJobsFileOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(path, []byte(testJobs), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := LoadJobs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		job       string
+		overrides []string
+		wantErr   bool
+		check     func(t *testing.T, opts *Options)
+	}{
+		{
+			name: "job overrides defaults",
+			job:  "shop",
+			check: func(t *testing.T, opts *Options) {
+				if opts.User != "backup" || opts.Host != "db1.example.com" || opts.Compression != "zip" || opts.Retries != 2 {
+					t.Errorf("user %q, host %q, compression %q, retries %d", opts.User, opts.Host, opts.Compression, opts.Retries)
+				}
+				if want := []string{"shop_*", "billing"}; !slices.Equal(opts.Databases, want) {
+					t.Errorf("databases %q, want %q", opts.Databases, want)
+				}
+				if want := []string{"shop_main.sessions"}; !slices.Equal(opts.ExcludeTables, want) {
+					t.Errorf("exclude %q, want %q", opts.ExcludeTables, want)
+				}
+				if want := []string{"--single-transaction", "--where", "id > 10"}; !slices.Equal(opts.Passthrough, want) {
+					t.Errorf("passthrough %q, want %q", opts.Passthrough, want)
+				}
+				if opts.Job != "shop" {
+					t.Errorf("job %q, want shop", opts.Job)
+				}
+			},
+		},
+		{
+			name: "defaults and booleans",
+			job:  "blog",
+			check: func(t *testing.T, opts *Options) {
+				if opts.Compression != "tzst" || !opts.SeparateDumps || !slices.Equal(opts.Databases, []string{"blog"}) {
+					t.Errorf("compression %q, separate dumps %v, databases %q", opts.Compression, opts.SeparateDumps, opts.Databases)
+				}
+			},
+		},
+		{
+			name:      "command line overrides the job",
+			job:       "shop",
+			overrides: []string{"--databases=other", "--compression", "gz"},
+			check: func(t *testing.T, opts *Options) {
+				if opts.Compression != "gz" || !slices.Equal(opts.Databases, []string{"other"}) {
+					t.Errorf("compression %q, databases %q", opts.Compression, opts.Databases)
+				}
+				if opts.Host != "db1.example.com" {
+					t.Errorf("host %q, want db1.example.com", opts.Host)
+				}
+			},
+		},
+		{name: "unknown key", job: "broken", wantErr: true},
+		{name: "unknown job", job: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := jobs.Options(tt.job, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Options(%q) error = %v, want error %v", tt.job, err, tt.wantErr)
+			}
+			if err == nil {
+				tt.check(t, opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package daemon runs the jobs of a jobs file on their cron schedules and
+// serves the result of their last runs over HTTP.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// Daemon schedules the jobs of a jobs file.
+type Daemon struct {
+	jobs    *config.JobsFile
+	opts    *config.DaemonOptions
+	started time.Time
+	states  []*jobState
+	// running counts the runs in progress, so that shutdown can wait for them
+	running sync.WaitGroup
+}
+
+// jobState is the schedule and the run history of one job.
+type jobState struct {
+	name     string
+	schedule *config.JobSchedule
+	log      *logging.Logger
+
+	mu      sync.Mutex
+	active  bool
+	next    time.Time
+	runs    int
+	lastRun *Run
+	// lastSuccess is the end of the last successful run
+	lastSuccess time.Time
+	// byStatus counts the runs by status
+	byStatus map[string]int
+	// skipped counts the runs skipped because the previous one was running
+	skipped int
+}
+
+// Run is the outcome of one run of a job.
+type Run struct {
+	RunID    string    `json:"run_id,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Status is the status of the backup, or "error" when it could not
+	// start, e.g. because of invalid options
+	Status   string         `json:"status"`
+	ExitCode int            `json:"exit_code"`
+	Error    string         `json:"error,omitempty"`
+	Report   *dumper.Report `json:"report,omitempty"`
+}
+
+// New returns a daemon for the jobs of the file that have a schedule.
+func New(jobs *config.JobsFile, opts *config.DaemonOptions) (*Daemon, error) {
+	d := &Daemon{jobs: jobs, opts: opts}
+	for _, name := range jobs.Names() {
+		schedule, err := jobs.Schedule(name)
+		if err != nil {
+			return nil, err
+		}
+		if schedule == nil {
+			logging.Info("Job %s has no schedule; it only runs with mymagicdump run.", name)
+			continue
+		}
+		// Catch invalid options now rather than at the first run
+		if _, err := jobs.Options(name, nil); err != nil {
+			return nil, err
+		}
+		d.states = append(d.states, &jobState{name: name, schedule: schedule, log: logging.With("job", name), byStatus: map[string]int{}})
+	}
+	if len(d.states) == 0 {
+		return nil, errors.New("no job has a schedule")
+	}
+	return d, nil
+}
+
+// Run schedules the jobs until ctx is cancelled, then waits for the runs in
+// progress to finish.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.started = time.Now()
+	servers, err := d.serve()
+	if err != nil {
+		return err
+	}
+	var schedulers sync.WaitGroup
+	for _, j := range d.states {
+		schedulers.Add(1)
+		go func() {
+			defer schedulers.Done()
+			d.schedule(ctx, j)
+		}()
+	}
+	<-ctx.Done()
+	schedulers.Wait()
+	logging.Info("Shutting down; waiting for running jobs to finish.")
+	d.running.Wait()
+	for _, srv := range servers {
+		srv.close()
+	}
+	return nil
+}
+
+// schedule starts the runs of a job at the times of its schedule. A run that
+// is due while the previous one is still going is skipped.
+func (d *Daemon) schedule(ctx context.Context, j *jobState) {
+	for {
+		next := j.schedule.Schedule.Next(time.Now())
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+		j.log.Debug("Next run of job %s at %s", j.name, next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.start() {
+			j.mu.Lock()
+			j.skipped++
+			j.mu.Unlock()
+			j.log.Warn("Job %s is still running; skipping the run due at %s.", j.name, next.Format(time.RFC3339))
+			continue
+		}
+		d.running.Add(1)
+		go func() {
+			defer d.running.Done()
+			defer j.finish()
+			d.execute(ctx, j)
+		}()
+	}
+}
+
+// start marks the job as running, unless it already is.
+func (j *jobState) start() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.active {
+		return false
+	}
+	j.active = true
+	return true
+}
+
+func (j *jobState) finish() {
+	j.mu.Lock()
+	j.active = false
+	j.mu.Unlock()
+}
+
+// execute runs a job once, after its random start delay, with a new
+// dumper.Runner.
+func (d *Daemon) execute(ctx context.Context, j *jobState) {
+	if j.schedule.Jitter > 0 {
+		delay := rand.N(j.schedule.Jitter)
+		j.log.Info("Job %s starts in %s.", j.name, delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+	run := &Run{Started: time.Now()}
+	log := j.log
+	// The options are read again for every run, as Prepare changes them
+	opts, err := d.jobs.Options(j.name, nil)
+	if err == nil {
+		// Progress bars and the summary are for terminals; the status
+		// endpoint and notifications report the outcome
+		opts.Silent = true
+		r := dumper.NewRunner(opts)
+		run.RunID = r.RunID
+		log = log.With("run_id", r.RunID)
+		log.Info("Running job %s", j.name)
+		if err = r.Prepare(); err == nil {
+			// A run that started is allowed to finish on shutdown
+			err = r.Run(context.WithoutCancel(ctx))
+			run.Report = r.Report()
+			run.Status = r.Status().String()
+		}
+	}
+	run.Finished = time.Now()
+	run.ExitCode = dumper.ExitCode(err)
+	if run.Status == "" {
+		run.Status = "error"
+	}
+	if err != nil {
+		run.Error = logging.Redact(err.Error())
+		log.Error("Job %s failed: %s", j.name, run.Error)
+	} else {
+		log.Info("Job %s finished successfully in %s.", j.name, run.Finished.Sub(run.Started).Round(time.Second))
+	}
+	j.mu.Lock()
+	j.runs++
+	j.lastRun = run
+	j.byStatus[run.Status]++
+	if err == nil {
+		j.lastSuccess = run.Finished
+	}
+	j.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package daemon
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/metrics"
+)
+
+// handleMetrics serves the metrics of the last run of every job, as written
+// to the textfile by one-shot runs, and counters of the runs since the
+// daemon started.
+func (d *Daemon) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	runs := metrics.Family{Name: "mymagicdump_runs_total", Type: metrics.Counter,
+		Help: "Runs since the daemon started, by status."}
+	skipped := metrics.Family{Name: "mymagicdump_skipped_runs_total", Type: metrics.Counter,
+		Help: "Runs skipped because the previous run of the job was still going."}
+	running := metrics.Family{Name: "mymagicdump_job_running", Type: metrics.Gauge,
+		Help: "Whether a run of the job is in progress."}
+	next := metrics.Family{Name: "mymagicdump_next_run_timestamp_seconds", Type: metrics.Gauge,
+		Help: "Time of the next scheduled run."}
+	sets := [][]metrics.Family{nil}
+	for _, j := range d.states {
+		j.mu.Lock()
+		statuses := make([]string, 0, len(j.byStatus))
+		for status := range j.byStatus {
+			statuses = append(statuses, status)
+		}
+		slices.Sort(statuses)
+		for _, status := range statuses {
+			runs.Add(float64(j.byStatus[status]), "job", j.name, "status", status)
+		}
+		skipped.Add(float64(j.skipped), "job", j.name)
+		running.Add(boolValue(j.active), "job", j.name)
+		if !j.next.IsZero() {
+			next.Add(float64(j.next.Unix()), "job", j.name)
+		}
+		if last := j.lastRun; last != nil {
+			sets = append(sets, dumper.Metrics(j.name, last.Report, last.ExitCode, j.lastSuccess))
+		}
+		j.mu.Unlock()
+	}
+	sets[0] = []metrics.Family{runs, skipped, running, next}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.Write(w, metrics.Merge(sets...)); err != nil {
+		logging.Debug("Failed to send metrics: %v", err)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
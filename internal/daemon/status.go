@@ -0,0 +1,151 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/version"
+)
+
+// Status is the document served at /status.
+type Status struct {
+	Version string      `json:"version"`
+	Started time.Time   `json:"started"`
+	Jobs    []JobStatus `json:"jobs"`
+}
+
+// JobStatus is the state of one job, served at /status/<job>.
+type JobStatus struct {
+	Name          string    `json:"name"`
+	Schedule      string    `json:"schedule"`
+	JitterSeconds float64   `json:"jitter_seconds"`
+	Running       bool      `json:"running"`
+	NextRun       time.Time `json:"next_run"`
+	Runs          int       `json:"runs"`
+	LastRun       *Run      `json:"last_run"`
+}
+
+func (j *jobState) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return JobStatus{
+		Name:          j.name,
+		Schedule:      j.schedule.Spec,
+		JitterSeconds: j.schedule.Jitter.Seconds(),
+		Running:       j.active,
+		NextRun:       j.next,
+		Runs:          j.runs,
+		LastRun:       j.lastRun,
+	}
+}
+
+// server is one HTTP listener of the daemon.
+type server struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// serve starts the status and metrics endpoints. They share a server when
+// they listen on the same address.
+func (d *Daemon) serve() ([]*server, error) {
+	muxes := map[string]*http.ServeMux{}
+	var addrs []string
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+			addrs = append(addrs, addr)
+		}
+		return muxes[addr]
+	}
+	if d.opts.StatusListen != "" {
+		m := mux(d.opts.StatusListen)
+		m.HandleFunc("GET /status", d.handleStatus)
+		m.HandleFunc("GET /status/{job}", d.handleJobStatus)
+	}
+	if d.opts.MetricsListen != "" {
+		mux(d.opts.MetricsListen).HandleFunc("GET /metrics", d.handleMetrics)
+	}
+	var servers []*server
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, s := range servers {
+				s.close()
+			}
+			return nil, err
+		}
+		s := &server{srv: &http.Server{Handler: muxes[addr], ReadHeaderTimeout: 10 * time.Second}, ln: ln}
+		go func() {
+			if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logging.Error("HTTP server on %s failed: %v", ln.Addr(), err)
+			}
+		}()
+		servers = append(servers, s)
+		if addr == d.opts.StatusListen {
+			logging.Info("Serving job status on http://%s/status", ln.Addr())
+		}
+		if addr == d.opts.MetricsListen {
+			logging.Info("Serving metrics on http://%s/metrics", ln.Addr())
+		}
+	}
+	return servers, nil
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := Status{Version: version.Version, Started: d.started, Jobs: []JobStatus{}}
+	for _, j := range d.states {
+		st.Jobs = append(st.Jobs, j.status())
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (d *Daemon) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	for _, j := range d.states {
+		if j.name == r.PathValue("job") {
+			writeJSON(w, http.StatusOK, j.status())
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "no scheduled job named " + r.PathValue("job")})
+}
+
+func (s *server) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.srv.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -24,21 +24,36 @@
 package dumper
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"filippo.io/age"
 	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
 
 	"github.com/trustservers-hosting/mymagicdump/internal/compress"
 	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/encrypt"
+	"github.com/trustservers-hosting/mymagicdump/internal/engine"
 	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
 	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+	"github.com/trustservers-hosting/mymagicdump/internal/notify"
+	"github.com/trustservers-hosting/mymagicdump/internal/retention"
+	"github.com/trustservers-hosting/mymagicdump/internal/upload"
 )
 
 type Runner struct {
@@ -46,185 +61,832 @@ type Runner struct {
 	ConnFlags     []string
 	DumpFlagsList [][]string
 	OutputFiles   []string
+	// Results holds the outcome of every dump in DumpFlagsList order
+	Results []DumpResult
+	// Started and Finished are set by Run
+	Started  time.Time
+	Finished time.Time
+	// archive receives the dump output directly when --stream is enabled
+	archive *compress.Archive
+	// recipients of the encrypted output when --encrypt is enabled
+	recipients []age.Recipient
+	// destinations the finished files are uploaded to
+	destinations []upload.Destination
+	// outputRoot is the original --output and runDir the name of this run's
+	// subdirectory in it when --timestamped is enabled
+	outputRoot string
+	runDir     string
+	// failures counts the dumps that failed after all retries
+	failures int
+	// compressErr is set when creating the archive or compressing failed
+	compressErr error
+	// outputErr is set when MANIFEST.json or RESTORE_ORDER.json could not
+	// be written
+	outputErr error
+	// compressedBytes is the size of the compressed dump files, 0 without
+	// compression
+	compressedBytes int64
+	// checksums holds the SHA-256 of output files hashed for the manifest
+	checksums map[string]string
+	// report is the report of the finished run, built by Report on first use
+	report *Report
+	// uploadErrs holds the last error of every destination that did not
+	// receive all files
+	uploadErrs []error
+	// mailer sends the --notify summary
+	mailer *notify.Email
+	// webhooks receive the JSON report of the run
+	webhooks []*notify.Webhook
+	// engine runs the dumps, mysqldump or the native engine
+	engine engine.Engine
+	// board shows the progress of the dumps running in parallel with --jobs
+	board *progressBoard
+	// layout describes the dump at every index of DumpFlagsList with
+	// --layout=per-table, and is nil otherwise
+	layout *manifest.Order
+	// snapshot is the global read lock held with --consistent-snapshot
+	snapshot *mysqlutil.ReadLock
+	// meta looks up databases, tables and their sizes on the server
+	meta mysqlutil.Metadata
+	// removeCredentials deletes the option file holding the password
+	removeCredentials func()
+	// RunID identifies the run in log messages and the report
+	RunID string
+	// runLog logs with the run ID and the job, and log also with the
+	// phase of the run
+	runLog *logging.Logger
+	log    *logging.Logger
+	// interactive is set when stderr is a terminal that can show progress
+	// bars
+	interactive bool
+	// EventOutput receives the events of the run as JSON lines (--events
+	// and --progress-fd), and OnEvent every event; both may be nil
+	EventOutput io.Writer
+	OnEvent     func(Event)
+	// OnLog receives the messages logged for the run
+	OnLog func(logging.Entry)
+	// Headless disables the progress bars, the summary and the mysqldump
+	// error output on the console, for runs embedded in another program
+	Headless bool
+	// events sends the events to EventOutput and OnEvent, nil without them
+	events *eventStream
+	// ctx cancels the dumps, retries and uploads of the run
+	ctx context.Context
 }
 
 func NewRunner(opts *config.Options) *Runner {
-	return &Runner{Opts: opts}
+	r := &Runner{
+		Opts:        opts,
+		checksums:   make(map[string]string),
+		RunID:       newRunID(),
+		interactive: term.IsTerminal(int(os.Stderr.Fd())),
+	}
+	r.runLog = logging.With("run_id", r.RunID)
+	if opts.Job != "" {
+		r.runLog = r.runLog.With("job", opts.Job)
+	}
+	r.setPhase("prepare")
+	return r
+}
+
+// newRunID returns a random ID for a run.
+func newRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AddDestination adds a destination the finished files are uploaded to,
+// besides those of --upload. It must be called before Prepare.
+func (r *Runner) AddDestination(dest upload.Destination) {
+	r.destinations = append(r.destinations, dest)
+}
+
+// setPhase sets the phase of the run its messages are logged with.
+func (r *Runner) setPhase(phase string) {
+	r.log = r.runLog.With("phase", phase)
 }
 
-func (r *Runner) Prepare() error {
-	r.ConnFlags = mysqlutil.BuildConnectionFlags(*r.Opts)
+func (r *Runner) Prepare() (err error) {
+	r.removeCredentials = func() {}
+	defer func() {
+		if err != nil {
+			r.removeCredentials()
+			r.writeMetrics(ExitError)
+			r.events.emit("run_finished", "status", "error", "exit_code", ExitError, "errors", []string{logging.Redact(err.Error())})
+		}
+	}()
+	if r.OnLog != nil {
+		r.runLog = r.runLog.WithHook(r.OnLog)
+		r.setPhase("prepare")
+	}
+	r.events = r.openEvents()
+	if r.Opts.Encrypt {
+		recipients, err := encrypt.Recipients(r.Opts.EncryptRecipients, r.Opts.RecipientsFile, r.Opts.PassphraseFile)
+		if err != nil {
+			r.log.Error("Invalid encryption settings: %v", err)
+			return err
+		}
+		r.recipients = recipients
+		// Encrypting afterwards would leave plaintext dumps in the output directory
+		if !r.Opts.Stream {
+			r.log.Info("--encrypt implies --stream; dumps are encrypted while they are written.")
+			r.Opts.Stream = true
+		}
+	} else if r.Opts.Stream && r.Opts.Compression == "none" {
+		r.log.Warn("--stream has no effect without --compression; dumping to plain files.")
+		r.Opts.Stream = false
+	}
+	if err := r.compressionSettings().Validate(); err != nil {
+		r.log.Error("Invalid compression settings: %v", err)
+		return err
+	}
+	if r.Opts.Jobs < 1 {
+		r.log.Error("Invalid --jobs %d; at least one dump must run at a time.", r.Opts.Jobs)
+		return fmt.Errorf("invalid --jobs %d", r.Opts.Jobs)
+	}
+	if r.Opts.Jobs > 1 && !r.Opts.SeparateDumps && r.Opts.Layout != LayoutPerTable {
+		r.log.Warn("--jobs has no effect without --separate-dumps or --layout=per-table; dumping one at a time.")
+		r.Opts.Jobs = 1
+	}
+	policy := r.retentionPolicy()
+	if err := policy.Validate(); err != nil {
+		r.log.Error("Invalid retention settings: %v", err)
+		return err
+	}
+	if policy.Enabled() && !r.Opts.Timestamped {
+		r.log.Info("--keep-* options imply --timestamped; each run is written to its own directory.")
+		r.Opts.Timestamped = true
+	}
+	if r.Opts.Timestamped {
+		r.outputRoot = r.Opts.OutputPath
+		r.runDir = retention.RunName(policy.Job, time.Now())
+		r.Opts.OutputPath = filepath.Join(r.outputRoot, r.runDir)
+	}
+	for _, u := range r.Opts.Upload {
+		dest, err := upload.Parse(u, r.Opts)
+		if err != nil {
+			r.log.Error("%v", err)
+			return err
+		}
+		r.destinations = append(r.destinations, dest)
+	}
+	if len(r.Opts.NotifyEmail) > 0 {
+		mailer, err := notify.NewEmail(r.Opts)
+		if err != nil {
+			r.log.Error("Invalid notification settings: %v", err)
+			return err
+		}
+		r.mailer = mailer
+	}
+	for _, u := range r.Opts.Webhooks {
+		w, err := notify.NewWebhook(u, r.Opts)
+		if err != nil {
+			r.log.Error("Invalid webhook settings: %v", err)
+			return err
+		}
+		r.webhooks = append(r.webhooks, w)
+	}
+	if err := mysqlutil.ResolvePassword(&r.Opts.ConnectionOptions); err != nil {
+		r.log.Error("Cannot read the MySQL password: %v", err)
+		return err
+	}
+	logging.AddSecret(r.Opts.Password, r.Opts.SMTPPassword, r.Opts.WebhookSecret)
+	if r.ConnFlags, r.removeCredentials, err = mysqlutil.BuildConnectionFlags(r.Opts.ConnectionOptions); err != nil {
+		r.log.Error("Failed to pass the password to mysqldump: %v", err)
+		return err
+	}
+	eng, err := engine.New(r.Opts.Engine, r.Opts.ConnectionOptions, r.ConnFlags)
+	if err != nil {
+		if r.Opts.Engine == engine.Mysqldump {
+			r.log.Error("Cannot find mysqldump or mariadb-dump in PATH; install one of them or use --engine=native.")
+		} else {
+			r.log.Error("Failed to set up the %s dump engine: %v", r.Opts.Engine, err)
+		}
+		return err
+	}
+	r.engine = eng
+	if r.meta, err = mysqlutil.NewMetadata(r.Opts.ConnectionOptions); err != nil {
+		r.log.Error("Invalid connection settings: %v", err)
+		return err
+	}
 	// Expand potential glob patterns in database list unless dumping all databases
 	if !r.Opts.AllDatabases && len(r.Opts.Databases) > 0 {
-		expanded, err := mysqlutil.ExpandDatabaseList(r.ConnFlags, r.Opts.Databases)
+		expanded, err := mysqlutil.ExpandDatabaseList(r.meta, r.Opts.Databases)
 		if err != nil {
-			logging.Warn("Failed to expand database patterns: %v", err)
+			r.log.Warn("Failed to expand database patterns: %v", err)
 		} else {
 			r.Opts.Databases = expanded
 		}
 		if len(r.Opts.Databases) == 0 {
-			logging.Error("No databases matched the provided patterns.")
+			r.log.Error("No databases matched the provided patterns.")
 			return fmt.Errorf("no databases matched provided patterns")
 		}
 	}
 	// compute tables based on patterns
-	excluded := constructExcludedTables(r.ConnFlags, r.Opts.ExcludeTables)
-	excludedData := constructExcludedTables(r.ConnFlags, r.Opts.ExcludeTablesData)
+	excluded := r.constructExcludedTables(r.Opts.ExcludeTables)
+	excludedData := r.constructExcludedTables(r.Opts.ExcludeTablesData)
 	// build dump flags
-	r.DumpFlagsList = buildDumpFlags(*r.Opts, excluded, excludedData)
+	if r.Opts.Layout == LayoutPerTable {
+		argsList, order, err := buildTableLayout(r.meta, *r.Opts, excluded, excludedData)
+		if err != nil {
+			r.log.Error("Failed to list the tables to dump: %v", err)
+			return err
+		}
+		r.DumpFlagsList, r.layout = argsList, order
+	} else {
+		r.DumpFlagsList = buildDumpFlags(*r.Opts, excluded, excludedData)
+	}
+	if r.Opts.ConsistentSnapshot {
+		if r.Opts.DryRun {
+			r.log.Info("Would hold a global read lock while dumping.")
+			return nil
+		}
+		lock, err := mysqlutil.LockForSnapshot(r.Opts.ConnectionOptions)
+		if err != nil {
+			r.log.Error("Failed to lock the server for a consistent snapshot: %v", err)
+			return err
+		}
+		r.snapshot = lock
+		s, ok := r.engine.(engine.Snapshotter)
+		if !ok {
+			r.log.Info("Holding a global read lock; writes are blocked until the dumps finish.")
+			return nil
+		}
+		err = s.Snapshot(context.Background(), min(r.Opts.Jobs, len(r.DumpFlagsList)))
+		r.releaseSnapshot()
+		if err != nil {
+			r.log.Error("Failed to start the snapshot transactions: %v", err)
+			return err
+		}
+	}
 	return nil
 }
 
-func (r *Runner) Run() error {
-	if r.Opts.DryRun {
-		logging.Info("Dry-run mode enabled. No commands will be executed.")
+// releaseSnapshot releases the global read lock of --consistent-snapshot.
+func (r *Runner) releaseSnapshot() {
+	if r.snapshot == nil {
+		return
+	}
+	if err := r.snapshot.Release(); err != nil {
+		r.log.Warn("Failed to release the global read lock: %v", err)
+	} else {
+		r.log.Info("Released the global read lock.")
+	}
+	r.snapshot = nil
+}
+
+// Run performs the dumps and post-processing, prints the summary, sends the
+// notifications and writes the metrics. A failed run returns a *RunError
+// describing what failed. Cancelling ctx stops the dumps, retries and
+// uploads; the notifications are still sent.
+func (r *Runner) Run(ctx context.Context) error {
+	defer r.removeCredentials()
+	r.ctx = ctx
+	r.Started = time.Now()
+	r.events.emit("run_started", "dumps", len(r.DumpFlagsList), "output", r.Opts.OutputPath, "dry_run", r.Opts.DryRun)
+	if err := r.run(); err != nil && r.outputErr == nil {
+		r.compressErr = err
 	}
-	for _, mysqlDumpFlags := range r.DumpFlagsList {
-		targetDatabases := mysqlutil.ExtractDatabasesFromFlags(mysqlDumpFlags)
-		dbSize, err := mysqlutil.CalculateDatabaseSize(r.ConnFlags, targetDatabases)
+	r.meta.Close()
+	r.Finished = time.Now()
+	r.setPhase("report")
+	if !r.Opts.DryRun {
+		r.printSummary()
+	}
+	r.notify()
+	r.writeMetrics(r.Status().ExitCode())
+	r.events.emit("run_finished", "status", r.Status().String(), "exit_code", r.Status().ExitCode(),
+		"duration_seconds", r.Finished.Sub(r.Started).Seconds(), "errors", append([]string{}, r.errors()...))
+	return r.Err()
+}
+
+// run dumps and post-processes the output. It only returns the errors of
+// creating, finishing or compressing the archive and of writing the manifest
+// or the restore order, which abort the run. The latter are also recorded in
+// outputErr.
+func (r *Runner) run() error {
+	r.setPhase("dump")
+	if r.Opts.DryRun {
+		r.log.Info("Dry-run mode enabled. No commands will be executed.")
+	} else if r.Opts.Stream {
+		os.MkdirAll(r.Opts.OutputPath, os.ModePerm)
+		archive, err := compress.NewArchive(r.streamPrefix(), r.compressionSettings())
 		if err != nil {
-			logging.Warn("Error calculating expected dump size: %v", err)
-		} else {
-			logging.Info("Estimated database size: %d bytes", dbSize)
+			r.log.Error("Failed to create archive: %v", err)
+			return err
 		}
-		logging.Info("Starting backup for databases: %s", strings.Join(targetDatabases, ", "))
-
-		if err := r.dumpWithRetries(mysqlDumpFlags, int64(dbSize)); err != nil {
-			logging.Error("Backup failed after all retries.")
+		r.archive = archive
+	}
+	r.dumpAll()
+	r.engine.Close()
+	r.releaseSnapshot()
+	for _, res := range r.Results {
+		if res.Err != nil {
+			r.failures++
 		}
 	}
 	// post-process
 	if r.Opts.DryRun {
 		return nil
 	}
-	if r.Opts.RemoveDefiners {
-		r.removeDefiners()
+	r.setPhase("compress")
+	if r.archive != nil {
+		var orderErr error
+		if r.layout != nil {
+			if orderErr = r.writeOrder(); orderErr != nil {
+				r.log.Error("Failed to write %s: %v", manifest.OrderFileName, orderErr)
+				r.archive.Skip(len(r.DumpFlagsList))
+				r.outputErr = orderErr
+			}
+		}
+		if err := r.closeArchive(); err != nil {
+			return err
+		}
+		if orderErr != nil {
+			return orderErr
+		}
+	} else {
+		// In DumpFlagsList order, whichever dump finished first
+		for _, res := range r.Results {
+			if res.Err == nil {
+				r.OutputFiles = append(r.OutputFiles, filepath.Join(r.Opts.OutputPath, res.Name))
+			}
+		}
+		if r.Opts.RemoveDefiners {
+			r.removeDefiners()
+		}
+		if r.layout != nil {
+			if err := r.writeOrder(); err != nil {
+				r.log.Error("Failed to write %s: %v", manifest.OrderFileName, err)
+				r.outputErr = err
+				return err
+			}
+		}
+		// compression prefix
+		prefix := compressionPrefix(r.Opts, r.OutputFiles)
+		settings := r.compressionSettings()
+		report := r.events != nil && settings.Type != "none" && len(r.OutputFiles) > 0
+		if report {
+			p := &compressionProgress{events: r.events, total: totalSize(r.OutputFiles)}
+			settings.Progress = p.add
+			r.events.emit("compression_started", "type", settings.Type, "files", len(r.OutputFiles), "total_bytes", p.total)
+		}
+		err := compress.ApplyCompression(prefix, settings, r.OutputFiles)
+		compressed := compressedFiles(prefix, settings, r.OutputFiles)
+		if report {
+			status := "success"
+			if err != nil {
+				status = "failed"
+			}
+			r.events.emit("compression_finished", "status", status, "files", compressed, "bytes", totalSize(compressed), "error", eventError(err))
+		}
+		if err != nil {
+			// Dumps whose archive failed are kept, report what is on disk
+			r.OutputFiles = slices.DeleteFunc(append(compressed, r.OutputFiles...), func(f string) bool {
+				_, err := os.Stat(f)
+				return err != nil
+			})
+			return err
+		}
+		r.OutputFiles = compressed
+		r.removeEmptyDirs()
+	}
+	if r.Opts.Compression != "none" {
+		r.compressedBytes = totalSize(r.OutputFiles)
+	}
+	r.setPhase("manifest")
+	if err := r.writeManifest(); err != nil {
+		r.log.Error("Failed to write the manifest: %v", err)
+		r.outputErr = err
+		return err
+	}
+	r.setPhase("upload")
+	complete := r.uploadOutput()
+	if r.failures == 0 && len(r.OutputFiles) > 0 {
+		r.setPhase("retention")
+		r.applyRetention(complete)
 	}
-	// compression prefix
-	prefix := compressionPrefix(r.Opts, r.OutputFiles)
-	compress.ApplyCompression(prefix, r.Opts.Compression, r.OutputFiles)
 	return nil
 }
 
-// dumpWithRetries runs a single dump command with retries.
-func (r *Runner) dumpWithRetries(mysqlDumpFlags []string, dbSize int64) error {
+// dumpAll runs the dumps in DumpFlagsList, up to --jobs at a time, and
+// records their results. Every running dump holds one server connection, so
+// --jobs also bounds the number of connections the run opens.
+func (r *Runner) dumpAll() {
+	r.Results = make([]DumpResult, len(r.DumpFlagsList))
+	jobs := min(r.Opts.Jobs, len(r.DumpFlagsList))
+	if jobs > 1 {
+		r.log.Info("Running %d dumps, %d at a time", len(r.DumpFlagsList), jobs)
+		if !r.Opts.Silent && !r.Headless && !r.Opts.DryRun && r.interactive {
+			r.board = newProgressBoard(os.Stderr, len(r.DumpFlagsList))
+			logging.SetOutput(r.board)
+			defer func() {
+				r.board.Stop()
+				logging.SetOutput(os.Stderr)
+				r.board = nil
+			}()
+		}
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r.dump(i)
+			}
+		}()
+	}
+	for i := range r.DumpFlagsList {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// dump runs the dump at index i of DumpFlagsList and stores its result in
+// r.Results[i].
+func (r *Runner) dump(i int) {
+	mysqlDumpFlags := r.DumpFlagsList[i]
+	name := r.dumpName(i)
+	targetDatabases, dbSize, err := r.estimateSize(i)
+	log := r.log.With("database", strings.Join(targetDatabases, ","))
+	if err != nil {
+		log.Warn("Error calculating expected dump size: %v", err)
+	} else if dbSize > 0 || r.layout == nil {
+		log.Info("Estimated size of %s: %d bytes", name, dbSize)
+	}
+	log.Info("Starting backup of %s for databases: %s", name, strings.Join(targetDatabases, ", "))
+
+	res := &r.Results[i]
+	*res = DumpResult{
+		Name:           name,
+		Databases:      targetDatabases,
+		Args:           mysqlDumpFlags,
+		EstimatedBytes: int64(dbSize),
+	}
+	err = r.dumpWithRetries(i, res, mysqlDumpFlags, log)
+	if err != nil {
+		log.Error("Backup of %s failed after all retries.", res.Name)
+		if r.archive != nil {
+			r.archive.Skip(i)
+		}
+	}
+	if r.board != nil {
+		r.board.Done(err != nil)
+	}
+	status := "success"
+	if err != nil {
+		status = "failed"
+	}
+	r.events.emit("dump_finished", "dump", res.Name, "databases", res.Databases, "status", status, "bytes", res.Bytes,
+		"duration_seconds", res.Duration.Seconds(), "attempts", res.Attempts, "error", eventError(err))
+}
+
+// totalSize returns the size of the files that exist.
+func totalSize(files []string) int64 {
+	var n int64
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+// eventError returns the message of err without secrets for an event, or
+// nil to leave the error out.
+func eventError(err error) any {
+	if err == nil {
+		return nil
+	}
+	return logging.Redact(err.Error())
+}
+
+// dumpName returns the name of the file of the dump at index i, relative
+// to the output directory.
+func (r *Runner) dumpName(i int) string {
+	if r.layout != nil {
+		return filepath.FromSlash(r.layout.Steps[i].File)
+	}
+	return outputNameFromFlags(r.Opts, r.DumpFlagsList[i])
+}
+
+// estimateSize returns the databases the dump at index i covers and their
+// size. For per-table dumps only the data of a table has a size estimate.
+func (r *Runner) estimateSize(i int) ([]string, int, error) {
+	if r.layout == nil {
+		databases, err := mysqlutil.ExtractDatabasesFromFlags(r.meta, r.DumpFlagsList[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		size, err := r.meta.DatabaseSize(databases)
+		return databases, size, err
+	}
+	step := r.layout.Steps[i]
+	if step.Kind != manifest.KindData {
+		return []string{step.Database}, 0, nil
+	}
+	size, err := r.meta.TableSize(step.Database, step.Table)
+	return []string{step.Database}, size, err
+}
+
+// uploadOutput copies the finished files to every --upload destination and
+// returns the destinations that received all of them. With
+// --delete-after-upload, files that reached all destinations are removed.
+func (r *Runner) uploadOutput() []upload.Destination {
+	if len(r.OutputFiles) == 0 || len(r.destinations) == 0 {
+		return nil
+	}
+	retryInterval := time.Duration(r.Opts.RetryInterval) * time.Second
+	uploads := make(map[string]int)
+	var complete []upload.Destination
+	for _, dest := range r.destinations {
+		r.log.Info("Uploading %d file(s) to %s", len(r.OutputFiles), dest)
+		r.events.emit("upload_started", "destination", dest.String(), "files", len(r.OutputFiles))
+		uploaded, err := upload.UploadFiles(r.ctx, dest, r.Opts.OutputPath, r.runDir, r.OutputFiles, r.Opts.Retries, retryInterval)
+		if err != nil {
+			r.uploadErrs = append(r.uploadErrs, fmt.Errorf("upload to %s: %w", dest, err))
+		}
+		status := "success"
+		if err != nil {
+			status = "failed"
+		}
+		r.events.emit("upload_finished", "destination", dest.String(), "status", status, "files", len(uploaded), "error", eventError(err))
+		for _, f := range uploaded {
+			uploads[f]++
+		}
+		if len(uploaded) == len(r.OutputFiles) {
+			complete = append(complete, dest)
+		}
+	}
+	if !r.Opts.DeleteAfterUpload {
+		return complete
+	}
+	for _, f := range r.OutputFiles {
+		if uploads[f] != len(r.destinations) {
+			r.log.Warn("Keeping %s; it was not uploaded to every destination", f)
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			r.log.Warn("Failed to delete %s: %v", f, err)
+		} else {
+			r.log.Debug("Deleted local copy %s", f)
+		}
+	}
+	r.removeEmptyDirs()
+	if r.runDir != "" {
+		// Only succeeds once every file is gone
+		os.Remove(r.Opts.OutputPath)
+	}
+	return complete
+}
+
+// dumpWithRetries runs a single dump command with retries and records the
+// attempts, size and duration of the last attempt in res. seq is the index
+// of the dump in DumpFlagsList and log logs with its databases.
+func (r *Runner) dumpWithRetries(seq int, res *DumpResult, mysqlDumpFlags []string, log *logging.Logger) error {
 	attempts := r.Opts.Retries + 1
-	var lastErr error
 	for i := 0; i < attempts; i++ {
-		logging.Info("Attempt %d/%d for dumping database(s)", i+1, attempts)
-		if err := r.singleDump(mysqlDumpFlags, dbSize); err != nil {
-			lastErr = err
+		if err := r.ctx.Err(); err != nil {
+			res.Err = err
+			return err
+		}
+		log := log.With("attempt", i+1)
+		log.Info("Attempt %d/%d for dumping %s", i+1, attempts, res.Name)
+		res.Attempts = i + 1
+		startTime := time.Now()
+		r.events.emit("dump_started", "dump", res.Name, "databases", res.Databases, "attempt", i+1, "estimated_bytes", res.EstimatedBytes)
+		res.Bytes, res.Err = r.singleDump(seq, i+1, mysqlDumpFlags, res.EstimatedBytes, log)
+		res.Duration = time.Since(startTime)
+		if res.Err != nil {
+			if i < attempts-1 {
+				r.events.emit("dump_retry", "dump", res.Name, "attempt", i+1, "error", eventError(res.Err), "delay_seconds", r.Opts.RetryInterval)
+			}
+			if i < attempts-1 && r.Opts.RetryInterval > 0 {
+				log.Info("Retrying in %d seconds...", r.Opts.RetryInterval)
+				select {
+				case <-r.ctx.Done():
+				case <-time.After(time.Duration(r.Opts.RetryInterval) * time.Second):
+				}
+			}
 			continue
 		}
 		return nil
 	}
-	return lastErr
+	return res.Err
 }
 
-// singleDump executes one mysqldump/mariadb-dump run and waits for completion with a progress bar.
-func (r *Runner) singleDump(mysqlDumpFlags []string, dbSize int64) error {
-	// Prepare the dump command, appending any passthrough flags
-	mysqldumpArgs := r.buildDumpArgs(mysqlDumpFlags)
-
-	// Resolve mysqldump binary from PATH with fallback to mariadb-dump
-	binaryPath, err := resolveDumpBinary()
-	if err != nil {
-		logging.Error("mymagicdump is a mysqldump/mariadb-dump wrapper tool, cannot find mysqldump or mariadb-dump in PATH.")
-		return err
-	}
-	dumpCmd := exec.Command(binaryPath, mysqldumpArgs...)
-	logging.Debug("Executing command: %s", strings.Join(dumpCmd.Args, " "))
+// singleDump runs one dump with the selected engine and waits for completion
+// with a progress bar. It returns the number of bytes dumped.
+func (r *Runner) singleDump(seq, attempt int, mysqlDumpFlags []string, dbSize int64, log *logging.Logger) (int64, error) {
+	// Prepare the dump arguments, appending any passthrough flags
+	args := r.buildDumpArgs(mysqlDumpFlags)
+	log.Debug("Executing command: %s", strings.Join(r.engine.Command(args), " "))
 
 	// Exit early if in dry-run mode
 	if r.Opts.DryRun {
-		return nil
+		return 0, nil
+	}
+
+	if r.archive != nil {
+		return r.streamDump(seq, attempt, args, dbSize, log)
 	}
 
 	// Create output file
-	os.MkdirAll(r.Opts.OutputPath, os.ModePerm)
-	outputFilePath := filepath.Join(r.Opts.OutputPath, outputNameFromFlags(r.Opts, mysqlDumpFlags))
+	name := r.dumpName(seq)
+	outputFilePath := filepath.Join(r.Opts.OutputPath, name)
+	os.MkdirAll(filepath.Dir(outputFilePath), os.ModePerm)
 	outf, err := os.Create(outputFilePath)
 	if err != nil {
-		logging.Error("Failed to create output file %s: %v", outputFilePath, err)
-		return err
+		log.Error("Failed to create output file %s: %v", outputFilePath, err)
+		return 0, err
 	}
 	defer outf.Close()
 
-	// Set output and error streams for the command
-	dumpCmd.Stdout = outf
-	dumpCmd.Stderr = os.Stderr
+	// Error output is shown and kept for the report
+	stderr := &stderrTail{}
+	errOut := io.MultiWriter(r.console(), stderr)
 
-	// Start and wait for the command to complete
+	// Use a channel to detect when the dump completes
 	startTime := time.Now()
-	if err = dumpCmd.Start(); err != nil {
-		logging.Error("Failed to start mysqldump: %v", err)
-		return err
-	}
-	logging.Info("Dump process started...")
-
-	// Use a channel to detect when `mysqldump` completes
+	log.Info("Dump process for %s started...", name)
 	done := make(chan error, 1)
-	go func() { done <- dumpCmd.Wait() }()
+	go func() { done <- r.engine.Dump(r.ctx, args, outf, errOut) }()
 
 	// Create a progress bar
-	var bar *progressbar.ProgressBar
-	if !r.Opts.Silent {
-		bar = progressbar.DefaultBytes(dbSize, "Dumping database...")
-	}
+	bar := r.newProgress(name, dbSize, attempt, log)
 
 	// Monitor file size and update progress bar
-	if err := r.monitorDump(done, outputFilePath, bar); err != nil {
-		return err
+	fileSize := func() int64 {
+		if fi, err := os.Stat(outputFilePath); err == nil {
+			return fi.Size()
+		}
+		return 0
+	}
+	if err := r.monitorDump(done, fileSize, bar); err != nil {
+		return fileSize(), stderr.wrap(err)
 	}
 
-	// Mark success and record output
+	// Mark success
 	elapsed := time.Since(startTime)
-	r.OutputFiles = append(r.OutputFiles, outputFilePath)
-	if bar != nil {
-		bar.Finish()
+	log.Info("Dump of %s completed successfully in %s", name, elapsed)
+	return fileSize(), nil
+}
+
+// streamDump runs a dump with its output piped into the archive entry for
+// this dump. The entry is only committed once the dump has succeeded.
+func (r *Runner) streamDump(seq, attempt int, args []string, dbSize int64, log *logging.Logger) (int64, error) {
+	name := r.dumpName(seq)
+	entry, err := r.archive.Begin(seq, name)
+	if err != nil {
+		log.Error("Failed to create archive entry %s: %v", name, err)
+		return 0, err
 	}
-	logging.Info("Dump completed successfully in %s", elapsed)
+	var sink io.Writer = entry
+	var filter *definerFilter
+	if r.Opts.RemoveDefiners {
+		filter = &definerFilter{w: entry}
+		sink = filter
+	}
+	counter := &countingWriter{w: sink}
+	stderr := &stderrTail{}
+	errOut := io.MultiWriter(r.console(), stderr)
+
+	startTime := time.Now()
+	log.Info("Dump process started, streaming %s...", name)
+
+	// Dump only returns once the output has been fully written to the entry
+	done := make(chan error, 1)
+	go func() { done <- r.engine.Dump(r.ctx, args, counter, errOut) }()
+
+	bar := r.newProgress(name, dbSize, attempt, log)
+	if err := r.monitorDump(done, counter.Count, bar); err != nil {
+		entry.Discard()
+		return counter.Count(), stderr.wrap(err)
+	}
+	if filter != nil {
+		if err := filter.Flush(); err != nil {
+			entry.Discard()
+			log.Error("Failed to write %s: %v", name, err)
+			return 0, err
+		}
+	}
+	if err := entry.Commit(); err != nil {
+		log.Error("Failed to add %s to the archive: %v", name, err)
+		return 0, err
+	}
+	log.Info("Dump of %s completed successfully in %s (%d bytes)", name, time.Since(startTime), counter.Count())
+	return counter.Count(), nil
+}
+
+// streamPrefix returns the archive path (without extension) used in streaming
+// mode, following the same naming as compressionPrefix.
+func (r *Runner) streamPrefix() string {
+	if len(r.DumpFlagsList) == 1 {
+		return filepath.Join(r.Opts.OutputPath, r.dumpName(0))
+	}
+	return filepath.Join(r.Opts.OutputPath, "multiple_databases")
+}
+
+// closeArchive finishes the streaming archive; an archive without any dump in
+// it is removed.
+func (r *Runner) closeArchive() error {
+	if err := r.archive.Close(); err != nil {
+		r.log.Error("Failed to finish archive: %v", err)
+		return err
+	}
+	r.OutputFiles = r.archive.Files()
+	if len(r.OutputFiles) == 0 {
+		r.log.Info("No dump files produced; nothing was archived.")
+		return nil
+	}
+	r.log.Info("Streaming compression completed successfully: %s", strings.Join(r.OutputFiles, ", "))
 	return nil
 }
 
-// buildDumpArgs builds the full mysqldump argument slice from connection, passthrough, and dump flags.
-func (r *Runner) buildDumpArgs(mysqlDumpFlags []string) []string {
-	mysqldumpArgs := append([]string{}, r.ConnFlags...)
-	if len(r.Opts.Passthrough) > 0 {
-		mysqldumpArgs = append(mysqldumpArgs, r.Opts.Passthrough...)
+// compressionSettings returns the compression options for the compress package.
+func (r *Runner) compressionSettings() compress.Settings {
+	return compress.Settings{
+		Type:       r.Opts.Compression,
+		Level:      r.Opts.CompressionLevel,
+		Threads:    r.Opts.CompressionThreads,
+		Recipients: r.recipients,
 	}
+}
+
+// buildDumpArgs builds the mysqldump argument slice from passthrough and dump
+// flags; the engine adds the connection options.
+func (r *Runner) buildDumpArgs(mysqlDumpFlags []string) []string {
+	mysqldumpArgs := append([]string{}, r.Opts.Passthrough...)
 	mysqldumpArgs = append(mysqldumpArgs, mysqlDumpFlags...)
 	return mysqldumpArgs
 }
 
-// resolveDumpBinary returns the path to mysqldump or mariadb-dump.
-func resolveDumpBinary() (string, error) {
-	if path, err := exec.LookPath("mysqldump"); err == nil {
-		return path, nil
+// newProgress returns the progress display for a dump attempt: a row of the
+// board when dumps run in parallel, a progress bar on a terminal and log
+// lines otherwise, none with --silent or Headless. With --events or OnEvent
+// the progress is also sent as events.
+func (r *Runner) newProgress(name string, dbSize int64, attempt int, log *logging.Logger) progress {
+	var ps progresses
+	switch {
+	case r.Opts.Silent || r.Headless:
+	case r.board != nil:
+		ps = append(ps, r.board.Add(name, dbSize))
+	case r.interactive:
+		ps = append(ps, progressbar.DefaultBytes(dbSize, "Dumping database..."))
+	default:
+		ps = append(ps, newProgressLines(log, name, dbSize))
+	}
+	if r.events != nil {
+		ps = append(ps, &eventProgress{events: r.events, name: name, size: dbSize, attempt: attempt})
+	}
+	switch len(ps) {
+	case 0:
+		return nil
+	case 1:
+		return ps[0]
+	}
+	return ps
+}
+
+// console returns where the error output of mysqldump is shown.
+func (r *Runner) console() io.Writer {
+	if r.Headless {
+		return io.Discard
 	}
-	if path, err := exec.LookPath("mariadb-dump"); err == nil {
-		return path, nil
+	if r.board != nil {
+		return r.board
 	}
-	return "", fmt.Errorf("cannot find mysqldump or mariadb-dump in PATH")
+	return os.Stderr
 }
 
-// monitorDump updates the progress bar until the dump command finishes.
-func (r *Runner) monitorDump(done <-chan error, outputFilePath string, bar *progressbar.ProgressBar) error {
+// monitorDump updates the progress bar until the dump command finishes, and
+// then finishes the bar. size reports the number of bytes dumped so far.
+func (r *Runner) monitorDump(done <-chan error, size func() int64, bar progress) error {
 	for {
 		select {
 		case err := <-done:
+			if bar != nil {
+				if err != nil {
+					bar.Exit()
+				} else {
+					bar.Set64(size())
+					bar.Finish()
+				}
+			}
 			if err != nil {
 				if exiterr, ok := err.(*exec.ExitError); ok {
-					logging.Error("mysqldump exited with status %d.", exiterr.ExitCode())
+					r.log.Error("mysqldump exited with status %d.", exiterr.ExitCode())
 				}
 				return err
 			}
 			return nil
 		default:
 			if bar != nil {
-				if fi, err := os.Stat(outputFilePath); err == nil {
-					bar.Set64(fi.Size())
-				}
+				bar.Set64(size())
 			}
 			time.Sleep(time.Second)
 		}
@@ -255,6 +917,22 @@ func compressionPrefix(opts *config.Options, files []string) string {
 	return filepath.Join(opts.OutputPath, "multiple_databases")
 }
 
+// compressedFiles returns the files ApplyCompression leaves behind for the
+// given dump files.
+func compressedFiles(prefix string, settings compress.Settings, files []string) []string {
+	if settings.Type == "none" || len(files) == 0 {
+		return files
+	}
+	if compress.Bundles(settings.Type) {
+		return []string{prefix + compress.Extension(settings.Type)}
+	}
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f+compress.Extension(settings.Type))
+	}
+	return out
+}
+
 func buildDumpFlags(opts config.Options, excludedTables, excludedTablesData []string) [][]string {
 	argsList := [][]string{}
 	baseArgs := []string{}
@@ -325,19 +1003,19 @@ func buildDumpFlags(opts config.Options, excludedTables, excludedTablesData []st
 }
 
 // constructExcludedTables creates --ignore-table flags for excluded tables.
-func constructExcludedTables(connFlags []string, patternsList []string) []string {
+func (r *Runner) constructExcludedTables(patternsList []string) []string {
 	excludeFlags := []string{}
 	for _, pattern := range patternsList {
 		parts := strings.Split(pattern, ".")
 		if len(parts) != 2 {
-			logging.Error("Invalid exclude pattern: %s. Expected 'database.table'", pattern)
+			r.log.Error("Invalid exclude pattern: %s. Expected 'database.table'", pattern)
 			continue
 		}
 		dbName := parts[0]
 		globPattern := parts[1]
-		matchedTables, err := mysqlutil.GetTablesMatchingGlob(connFlags, dbName, globPattern)
+		matchedTables, err := mysqlutil.GetTablesMatchingGlob(r.meta, dbName, globPattern)
 		if err != nil {
-			logging.Error("Error retrieving tables for pattern %s: %v", pattern, err)
+			r.log.Error("Error retrieving tables for pattern %s: %v", pattern, err)
 			continue
 		}
 		for _, table := range matchedTables {
@@ -347,15 +1025,16 @@ func constructExcludedTables(connFlags []string, patternsList []string) []string
 	return excludeFlags
 }
 
+// Matches `DEFINER=... ` clauses
+var definerRe = regexp.MustCompile(`DEFINER=[^\s]+ `)
+
 // Removes the DEFINER clauses from any .sql files generated
 func (r *Runner) removeDefiners() {
-	// Remove `DEFINER=... `
-	definerRe := regexp.MustCompile(`DEFINER=[^\s]+ `)
 	for _, dumpFile := range r.OutputFiles {
 		// Read entire file
 		data, err := os.ReadFile(dumpFile)
 		if err != nil {
-			logging.Error("Couldn't remove Definers from %s: Failed to read: %v", dumpFile, err)
+			r.log.Error("Couldn't remove Definers from %s: Failed to read: %v", dumpFile, err)
 			continue
 		}
 
@@ -364,7 +1043,7 @@ func (r *Runner) removeDefiners() {
 
 		// If no change, skip rewrite
 		if string(processed) == string(data) {
-			logging.Info("No DEFINER clauses found in %s", dumpFile)
+			r.log.Info("No DEFINER clauses found in %s", dumpFile)
 			continue
 		}
 
@@ -372,27 +1051,94 @@ func (r *Runner) removeDefiners() {
 		dir := filepath.Dir(dumpFile)
 		tmp, err := os.CreateTemp(dir, filepath.Base(dumpFile)+".nodefiner-*")
 		if err != nil {
-			logging.Error("Failed to create temp file for %s: %v", dumpFile, err)
+			r.log.Error("Failed to create temp file for %s: %v", dumpFile, err)
 			return
 		}
 		tmpPath := tmp.Name()
 		if _, err := tmp.Write(processed); err != nil {
 			tmp.Close()
 			os.Remove(tmpPath)
-			logging.Error("Failed to write temp file for %s: %v", dumpFile, err)
+			r.log.Error("Failed to write temp file for %s: %v", dumpFile, err)
 			return
 		}
 		if err := tmp.Close(); err != nil {
 			os.Remove(tmpPath)
-			logging.Error("Failed to close temp file for %s: %v", dumpFile, err)
+			r.log.Error("Failed to close temp file for %s: %v", dumpFile, err)
 			return
 		}
 		if err := os.Rename(tmpPath, dumpFile); err != nil {
 			os.Remove(tmpPath)
-			logging.Error("Failed to replace original file %s: %v", dumpFile, err)
+			r.log.Error("Failed to replace original file %s: %v", dumpFile, err)
 			return
 		}
-		logging.Info("Successfully removed DEFINER clauses from %s", dumpFile)
+		r.log.Info("Successfully removed DEFINER clauses from %s", dumpFile)
+	}
+	r.log.Info("DEFINER clauses removal process completed.")
+}
+
+// definerFilter removes DEFINER clauses from a dump while it is being streamed.
+// It works line by line, buffering incomplete lines until Flush.
+type definerFilter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (f *definerFilter) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	if i := bytes.LastIndexByte(f.buf, '\n'); i >= 0 {
+		if _, err := f.w.Write(definerRe.ReplaceAll(f.buf[:i+1], nil)); err != nil {
+			return 0, err
+		}
+		f.buf = append(f.buf[:0], f.buf[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// Flush writes any remaining partial line.
+func (f *definerFilter) Flush() error {
+	_, err := f.w.Write(definerRe.ReplaceAll(f.buf, nil))
+	f.buf = nil
+	return err
+}
+
+// countingWriter counts the bytes passing through it.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far.
+func (c *countingWriter) Count() int64 {
+	return c.n.Load()
+}
+
+// stderrTail keeps the last few KiB written to it, so that the end of the
+// mysqldump error output can be reported with the failure.
+type stderrTail struct {
+	buf []byte
+}
+
+const stderrTailSize = 4096
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailSize {
+		t.buf = t.buf[len(t.buf)-stderrTailSize:]
+	}
+	return len(p), nil
+}
+
+// wrap adds the captured error output to err.
+func (t *stderrTail) wrap(err error) error {
+	msg := strings.TrimSpace(string(t.buf))
+	if msg == "" {
+		return err
 	}
-	logging.Info("DEFINER clauses removal process completed.")
+	return fmt.Errorf("%w: %s", err, msg)
 }
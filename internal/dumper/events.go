@@ -0,0 +1,166 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// eventInterval is the least time between two progress events of a dump or
+// of the compression.
+const eventInterval = time.Second
+
+// Event is one event of a run as passed to OnEvent. Fields are the fields
+// of its line in the --events stream besides time, event, run_id and job.
+type Event struct {
+	Time   time.Time
+	Name   string
+	Fields []logging.Field
+}
+
+// eventStream writes the --events json stream: one JSON object per line with
+// the time, the name of the event, the run ID, the job and the fields of the
+// event. It also passes the events to notify. A nil *eventStream discards
+// the events.
+type eventStream struct {
+	mu     sync.Mutex
+	w      io.Writer
+	notify func(Event)
+	prefix []byte
+}
+
+// openEvents returns the stream of the events of the run, or nil when
+// neither EventOutput nor OnEvent is set.
+func (r *Runner) openEvents() *eventStream {
+	if r.EventOutput == nil && r.OnEvent == nil {
+		return nil
+	}
+	var prefix bytes.Buffer
+	prefix.WriteString(`,"run_id":`)
+	writeJSONValue(&prefix, r.RunID)
+	if r.Opts.Job != "" {
+		prefix.WriteString(`,"job":`)
+		writeJSONValue(&prefix, r.Opts.Job)
+	}
+	return &eventStream{w: r.EventOutput, notify: r.OnEvent, prefix: prefix.Bytes()}
+}
+
+// emit sends an event with the given key and value pairs; pairs with a nil
+// value are left out. Events are sent one at a time.
+func (s *eventStream) emit(event string, keysAndValues ...any) {
+	if s == nil {
+		return
+	}
+	e := Event{Time: time.Now(), Name: event}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i+1] != nil {
+			e.Fields = append(e.Fields, logging.Field{Key: fmt.Sprint(keysAndValues[i]), Value: keysAndValues[i+1]})
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil {
+		// A consumer that went away must not fail the backup
+		s.w.Write(s.line(e))
+	}
+	if s.notify != nil {
+		s.notify(e)
+	}
+}
+
+// line returns e as a line of the --events stream.
+func (s *eventStream) line(e Event) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSONValue(&b, e.Time.Format(time.RFC3339Nano))
+	b.WriteString(`,"event":`)
+	writeJSONValue(&b, e.Name)
+	b.Write(s.prefix)
+	for _, f := range e.Fields {
+		b.WriteByte(',')
+		writeJSONValue(&b, f.Key)
+		b.WriteByte(':')
+		writeJSONValue(&b, f.Value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func writeJSONValue(b *bytes.Buffer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// eventProgress sends dump_progress events for one attempt of a dump.
+type eventProgress struct {
+	events  *eventStream
+	name    string
+	size    int64
+	attempt int
+	last    time.Time
+}
+
+func (p *eventProgress) Set64(n int64) error {
+	if time.Since(p.last) < eventInterval {
+		return nil
+	}
+	p.last = time.Now()
+	p.events.emit("dump_progress", "dump", p.name, "attempt", p.attempt, "bytes", n, "estimated_bytes", p.size)
+	return nil
+}
+
+func (p *eventProgress) Finish() error { return nil }
+
+func (p *eventProgress) Exit() error { return nil }
+
+// compressionProgress counts the bytes read by the compression and sends
+// compression_progress events.
+type compressionProgress struct {
+	events *eventStream
+	total  int64
+	mu     sync.Mutex
+	n      int64
+	last   time.Time
+}
+
+func (p *compressionProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += n
+	if time.Since(p.last) < eventInterval && p.n < p.total {
+		return
+	}
+	p.last = time.Now()
+	p.events.emit("compression_progress", "bytes", p.n, "total_bytes", p.total)
+}
@@ -0,0 +1,168 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
+	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+)
+
+// LayoutPerTable writes one file per table instead of one per database.
+const LayoutPerTable = "per-table"
+
+// systemDatabases are left out of --all-databases per-table dumps, like
+// mysqldump --all-databases does.
+var systemDatabases = []string{"information_schema", "performance_schema", "sys"}
+
+// buildTableLayout plans the dumps of --layout=per-table. Every database gets
+// its own directory with a file for its CREATE DATABASE, one for the
+// definition and one for the data of each table, and files for its routines
+// and events, views and triggers. The dumps are returned in restore order,
+// together with that order for RESTORE_ORDER.json.
+func buildTableLayout(meta mysqlutil.Metadata, opts config.Options, excludedTables, excludedTablesData []string) ([][]string, *manifest.Order, error) {
+	databases := opts.Databases
+	if opts.AllDatabases {
+		all, err := mysqlutil.ExpandDatabaseList(meta, []string{"*"})
+		if err != nil {
+			return nil, nil, err
+		}
+		databases = slices.DeleteFunc(all, func(db string) bool {
+			return slices.Contains(systemDatabases, db)
+		})
+	}
+	order := manifest.NewOrder(opts.ConsistentSnapshot)
+	var argsList [][]string
+	add := func(args []string, step manifest.Step) {
+		argsList = append(argsList, args)
+		order.Steps = append(order.Steps, step)
+	}
+	for _, db := range databases {
+		tables, views, err := meta.ListTables(db)
+		if err != nil {
+			return nil, nil, err
+		}
+		excluded := func(t string) bool { return slices.Contains(excludedTables, db+"."+t) }
+		tables = slices.DeleteFunc(tables, excluded)
+		views = slices.DeleteFunc(views, excluded)
+		dir := fileName(db)
+
+		add([]string{"--no-data", "--no-create-info", "--skip-triggers", "--databases", db},
+			manifest.Step{File: path.Join(dir, manifest.DatabaseFileName), Database: db, Kind: manifest.KindDatabase})
+		for _, t := range tables {
+			add([]string{"--no-data", "--skip-triggers", db, t},
+				manifest.Step{File: path.Join(dir, fileName(t)+"_schema.sql"), Database: db, Table: t, Kind: manifest.KindSchema})
+		}
+		for _, t := range tables {
+			if slices.Contains(excludedTablesData, db+"."+t) {
+				continue
+			}
+			add([]string{"--no-create-info", "--skip-triggers", db, t},
+				manifest.Step{File: path.Join(dir, fileName(t)+"_data.sql"), Database: db, Table: t, Kind: manifest.KindData})
+		}
+		add([]string{"--no-data", "--no-create-info", "--skip-triggers", "--routines", "--events", db},
+			manifest.Step{File: path.Join(dir, "_routines.sql"), Database: db, Kind: manifest.KindRoutines})
+		if len(views) > 0 {
+			add(append([]string{"--no-data", "--skip-triggers", db}, views...),
+				manifest.Step{File: path.Join(dir, "_views.sql"), Database: db, Kind: manifest.KindViews})
+		}
+		if len(tables) > 0 {
+			args := []string{"--no-data", "--no-create-info", "--triggers"}
+			for _, t := range excludedTables {
+				if strings.HasPrefix(t, db+".") {
+					args = append(args, "--ignore-table="+t)
+				}
+			}
+			add(append(args, db),
+				manifest.Step{File: path.Join(dir, "_triggers.sql"), Database: db, Kind: manifest.KindTriggers})
+		}
+	}
+	return argsList, order, nil
+}
+
+// fileName returns a database or table name usable as a file name; slashes
+// are encoded the way MySQL encodes them in its own data directory.
+func fileName(name string) string {
+	return strings.ReplaceAll(name, "/", "@002f")
+}
+
+// writeOrder writes RESTORE_ORDER.json listing the per-table dumps that
+// succeeded. In streaming mode it becomes the last member of the archive.
+func (r *Runner) writeOrder() error {
+	order := manifest.NewOrder(r.layout.Snapshot)
+	for i, res := range r.Results {
+		if res.Err == nil {
+			order.Steps = append(order.Steps, r.layout.Steps[i])
+		}
+	}
+	if r.archive != nil {
+		entry, err := r.archive.Begin(len(r.DumpFlagsList), manifest.OrderFileName)
+		if err != nil {
+			return err
+		}
+		if err := order.Encode(entry); err != nil {
+			entry.Discard()
+			return err
+		}
+		return entry.Commit()
+	}
+	path := filepath.Join(r.Opts.OutputPath, manifest.OrderFileName)
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = order.Encode(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	r.OutputFiles = append(r.OutputFiles, path)
+	return nil
+}
+
+// removeEmptyDirs removes the database directories of a per-table dump that
+// no longer hold any file, e.g. after archiving or uploading.
+func (r *Runner) removeEmptyDirs() {
+	if r.layout == nil {
+		return
+	}
+	for _, step := range r.layout.Steps {
+		if step.Kind != manifest.KindDatabase {
+			continue
+		}
+		dir := filepath.Join(r.Opts.OutputPath, filepath.FromSlash(path.Dir(step.File)))
+		if err := os.Remove(dir); err == nil {
+			r.log.Debug("Removed empty directory %s", dir)
+		}
+	}
+}
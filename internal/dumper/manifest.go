@@ -0,0 +1,84 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
+	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+	"github.com/trustservers-hosting/mymagicdump/internal/version"
+)
+
+// writeManifest writes MANIFEST.json (and SHA256SUMS) for the output files
+// into the output directory and adds them to OutputFiles, so that they are
+// uploaded with the backup.
+func (r *Runner) writeManifest() error {
+	if r.Opts.NoManifest || len(r.OutputFiles) == 0 {
+		return nil
+	}
+	m := manifest.New("mymagicdump " + version.Version)
+	m.Started = r.Started
+	if serverVersion, err := r.meta.ServerVersion(); err == nil {
+		m.ServerVersion = serverVersion
+	} else {
+		r.log.Debug("Cannot determine the server version: %v", err)
+	}
+	for _, res := range r.Results {
+		d := manifest.Dump{
+			Name:      filepath.ToSlash(res.Name),
+			Databases: res.Databases,
+			Args:      mysqlutil.RedactArgs(r.engine.Command(r.buildDumpArgs(res.Args))),
+			Status:    "success",
+		}
+		if res.Err != nil {
+			d.Status = "failed"
+			d.Error = logging.Redact(res.Err.Error())
+		}
+		m.Dumps = append(m.Dumps, d)
+	}
+	for _, f := range r.OutputFiles {
+		size, sum, err := manifest.HashFile(f)
+		if err != nil {
+			return err
+		}
+		r.checksums[f] = sum
+		name, err := filepath.Rel(r.Opts.OutputPath, f)
+		if err != nil {
+			name = filepath.Base(f)
+		}
+		m.Files = append(m.Files, manifest.File{Name: filepath.ToSlash(name), Size: size, SHA256: sum})
+	}
+	m.Finished = time.Now()
+	written, err := m.Write(r.Opts.OutputPath, r.Opts.SHA256Sums)
+	r.OutputFiles = append(r.OutputFiles, written...)
+	if err != nil {
+		return err
+	}
+	r.log.Info("Wrote %s", strings.Join(written, ", "))
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"cmp"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/metrics"
+)
+
+// lastSuccessMetric is carried over from the previous textfile when a run
+// fails, so that alerts can fire on its age.
+const lastSuccessMetric = "mymagicdump_last_success_timestamp_seconds"
+
+// Metrics returns the metrics of the last run of a job. rep is nil when the
+// run failed before dumping, e.g. because the server was unreachable;
+// lastSuccess is the end of the last successful run, zero if none is known.
+// The dump metrics are labelled by database; a dump of several databases
+// carries their names separated by commas.
+func Metrics(job string, rep *Report, exitCode int, lastSuccess time.Time) []metrics.Family {
+	exit := metrics.Family{Name: "mymagicdump_last_run_exit_code", Type: metrics.Gauge,
+		Help: "Exit code of the last run: 0 success, 1 error, 2 partial, 3 total, 4 compression, 5 upload, 6 output failure."}
+	exit.Add(float64(exitCode), "job", job)
+	success := metrics.Family{Name: lastSuccessMetric, Type: metrics.Gauge,
+		Help: "Time the last successful run finished."}
+	if !lastSuccess.IsZero() {
+		success.Add(unixSeconds(lastSuccess), "job", job)
+	}
+	families := []metrics.Family{exit, success}
+	if rep == nil {
+		return families
+	}
+
+	started := metrics.Family{Name: "mymagicdump_last_run_start_timestamp_seconds", Type: metrics.Gauge,
+		Help: "Time the last run started."}
+	started.Add(unixSeconds(rep.Started), "job", job)
+	duration := metrics.Family{Name: "mymagicdump_last_run_duration_seconds", Type: metrics.Gauge,
+		Help: "Duration of the last run, including compression and uploads."}
+	duration.Add(rep.DurationSeconds, "job", job)
+	compressed := metrics.Family{Name: "mymagicdump_last_run_compressed_bytes", Type: metrics.Gauge,
+		Help: "Size of the compressed dump files of the last run."}
+	ratio := metrics.Family{Name: "mymagicdump_last_run_compression_ratio", Type: metrics.Gauge,
+		Help: "Bytes dumped divided by the size of the compressed dump files in the last run."}
+	if rep.CompressedBytes > 0 {
+		var dumped int64
+		for _, d := range rep.Dumps {
+			dumped += d.Bytes
+		}
+		compressed.Add(float64(rep.CompressedBytes), "job", job)
+		ratio.Add(float64(dumped)/float64(rep.CompressedBytes), "job", job)
+	}
+	families = append(families, started, duration, compressed, ratio)
+
+	bytes := metrics.Family{Name: "mymagicdump_dump_bytes", Type: metrics.Gauge,
+		Help: "Bytes dumped in the last run."}
+	estimated := metrics.Family{Name: "mymagicdump_dump_estimated_bytes", Type: metrics.Gauge,
+		Help: "Size of the data reported by the server before dumping in the last run."}
+	dumpDuration := metrics.Family{Name: "mymagicdump_dump_duration_seconds", Type: metrics.Gauge,
+		Help: "Time spent dumping in the last run."}
+	retries := metrics.Family{Name: "mymagicdump_dump_retries", Type: metrics.Gauge,
+		Help: "Attempts beyond the first in the last run."}
+	failures := metrics.Family{Name: "mymagicdump_dump_failures", Type: metrics.Gauge,
+		Help: "Dumps that failed after all retries in the last run."}
+	// With --layout=per-table a database has many dumps, whose values
+	// are summed
+	type totals struct {
+		bytes, estimated, retries, failures int64
+		seconds                             float64
+	}
+	var order []string
+	byDatabase := map[string]*totals{}
+	for _, d := range rep.Dumps {
+		db := strings.Join(d.Databases, ",")
+		t, ok := byDatabase[db]
+		if !ok {
+			t = &totals{}
+			byDatabase[db] = t
+			order = append(order, db)
+		}
+		t.bytes += d.Bytes
+		t.estimated += d.EstimatedBytes
+		t.seconds += d.DurationSeconds
+		t.retries += int64(max(d.Attempts-1, 0))
+		if d.Status != "success" {
+			t.failures++
+		}
+	}
+	for _, db := range order {
+		t := byDatabase[db]
+		bytes.Add(float64(t.bytes), "job", job, "database", db)
+		estimated.Add(float64(t.estimated), "job", job, "database", db)
+		dumpDuration.Add(t.seconds, "job", job, "database", db)
+		retries.Add(float64(t.retries), "job", job, "database", db)
+		failures.Add(float64(t.failures), "job", job, "database", db)
+	}
+	return append(families, bytes, estimated, dumpDuration, retries, failures)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// metricsJob returns the job label of the metrics.
+func (r *Runner) metricsJob() string {
+	return cmp.Or(r.Opts.MetricsJob, r.Opts.Job, "mymagicdump")
+}
+
+// metricsFile returns the textfile of the job in --metrics-textfile-dir.
+func (r *Runner) metricsFile() string {
+	name := unsafeFileChars.ReplaceAllString(r.metricsJob(), "_")
+	return filepath.Join(r.Opts.MetricsTextfileDir, "mymagicdump_"+name+".prom")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// writeMetrics writes the metrics of the run to --metrics-textfile-dir. When
+// Prepare failed there is no run to report and only exitCode is written.
+func (r *Runner) writeMetrics(exitCode int) {
+	if r.Opts.MetricsTextfileDir == "" || r.Opts.DryRun {
+		return
+	}
+	var rep *Report
+	if !r.Finished.IsZero() {
+		rep = r.Report()
+	}
+	path := r.metricsFile()
+	var lastSuccess time.Time
+	if exitCode == ExitSuccess && rep != nil {
+		lastSuccess = rep.Finished
+	} else if v, ok := metrics.ReadValue(path, lastSuccessMetric); ok {
+		lastSuccess = time.Unix(0, int64(v*1e9))
+	}
+	if err := metrics.WriteFile(path, Metrics(r.metricsJob(), rep, exitCode, lastSuccess)); err != nil {
+		r.log.Error("Failed to write metrics: %v", err)
+		return
+	}
+	r.log.Debug("Wrote metrics to %s", path)
+}
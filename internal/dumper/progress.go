@@ -0,0 +1,281 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// progress reports how far a single dump is. It is implemented by
+// progressbar.ProgressBar, by the rows of a progressBoard, by progressLines
+// and by eventProgress.
+type progress interface {
+	Set64(n int64) error
+	Finish() error
+	Exit() error
+}
+
+// progresses reports to several progress at once.
+type progresses []progress
+
+func (ps progresses) Set64(n int64) error {
+	for _, p := range ps {
+		p.Set64(n)
+	}
+	return nil
+}
+
+func (ps progresses) Finish() error {
+	for _, p := range ps {
+		p.Finish()
+	}
+	return nil
+}
+
+func (ps progresses) Exit() error {
+	for _, p := range ps {
+		p.Exit()
+	}
+	return nil
+}
+
+// progressBoard draws one line per running dump when several dumps run at
+// once, below a line counting the finished ones. Everything else written to
+// the terminal while it is shown must go through Write so that it ends up
+// above the board instead of in the middle of it.
+type progressBoard struct {
+	mu      sync.Mutex
+	out     io.Writer
+	total   int
+	done    int
+	failed  int
+	rows    []*progressRow
+	lines   int
+	pending []byte
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// progressRow is the line of a single dump on a progressBoard.
+type progressRow struct {
+	board   *progressBoard
+	name    string
+	size    int64
+	started time.Time
+	n       atomic.Int64
+}
+
+const boardRefresh = 500 * time.Millisecond
+
+// newProgressBoard starts drawing a board for total dumps on out.
+func newProgressBoard(out io.Writer, total int) *progressBoard {
+	b := &progressBoard{
+		out:     out,
+		total:   total,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(b.stopped)
+		t := time.NewTicker(boardRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-t.C:
+				b.mu.Lock()
+				b.redraw()
+				b.mu.Unlock()
+			}
+		}
+	}()
+	return b
+}
+
+// Add adds a row for a dump of the given estimated size.
+func (b *progressBoard) Add(name string, size int64) *progressRow {
+	row := &progressRow{board: b, name: name, size: size, started: time.Now()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rows = append(b.rows, row)
+	b.redraw()
+	return row
+}
+
+// Write prints complete lines of p above the board.
+func (b *progressBoard) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, p...)
+	i := bytes.LastIndexByte(b.pending, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	b.clear()
+	_, err := b.out.Write(b.pending[:i+1])
+	b.pending = append(b.pending[:0], b.pending[i+1:]...)
+	b.draw()
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Stop removes the board from the terminal and prints any incomplete line.
+func (b *progressBoard) Stop() {
+	close(b.stop)
+	<-b.stopped
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	if len(b.pending) > 0 {
+		b.out.Write(append(b.pending, '\n'))
+		b.pending = nil
+	}
+}
+
+// Done counts a dump as finished after its last attempt.
+func (b *progressBoard) Done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done++
+	if failed {
+		b.failed++
+	}
+	b.redraw()
+}
+
+// remove drops row from the board.
+func (b *progressBoard) remove(row *progressRow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i := slices.Index(b.rows, row); i >= 0 {
+		b.rows = slices.Delete(b.rows, i, i+1)
+	}
+	b.redraw()
+}
+
+// clear erases the lines drawn last. b.mu must be held.
+func (b *progressBoard) clear() {
+	if b.lines > 0 {
+		fmt.Fprintf(b.out, "\x1b[%dA\x1b[J", b.lines)
+		b.lines = 0
+	}
+}
+
+// draw writes the board below the cursor. b.mu must be held.
+func (b *progressBoard) draw() {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Dumps: %d/%d finished", b.done, b.total)
+	if b.failed > 0 {
+		fmt.Fprintf(&sb, ", %d failed", b.failed)
+	}
+	sb.WriteByte('\n')
+	for _, r := range b.rows {
+		sb.WriteString(r.String())
+		sb.WriteByte('\n')
+	}
+	io.WriteString(b.out, sb.String())
+	b.lines = len(b.rows) + 1
+}
+
+func (b *progressBoard) redraw() {
+	b.clear()
+	b.draw()
+}
+
+// String formats the row, e.g. "  shop.sql  12.0 MiB / 40.0 MiB  30%  1m5s".
+func (r *progressRow) String() string {
+	n := r.n.Load()
+	elapsed := time.Since(r.started).Truncate(time.Second)
+	if r.size <= 0 {
+		return fmt.Sprintf("  %-30s %10s  %s", r.name, formatBytes(n), elapsed)
+	}
+	pct := min(n*100/r.size, 100)
+	return fmt.Sprintf("  %-30s %10s / %-10s %3d%%  %s", r.name, formatBytes(n), formatBytes(r.size), pct, elapsed)
+}
+
+func (r *progressRow) Set64(n int64) error {
+	r.n.Store(n)
+	return nil
+}
+
+// Finish removes the row once the dump has completed.
+func (r *progressRow) Finish() error {
+	r.board.remove(r)
+	return nil
+}
+
+// Exit removes the row after a failed dump attempt.
+func (r *progressRow) Exit() error {
+	r.board.remove(r)
+	return nil
+}
+
+// progressLineInterval is how often progressLines logs.
+const progressLineInterval = 10 * time.Second
+
+// progressLines logs the progress of a dump every progressLineInterval, in
+// place of a progress bar when stderr is not a terminal, e.g. in CI logs.
+type progressLines struct {
+	log     *logging.Logger
+	name    string
+	size    int64
+	started time.Time
+	last    time.Time
+}
+
+func newProgressLines(log *logging.Logger, name string, size int64) *progressLines {
+	now := time.Now()
+	return &progressLines{log: log, name: name, size: size, started: now, last: now}
+}
+
+func (p *progressLines) Set64(n int64) error {
+	if time.Since(p.last) < progressLineInterval {
+		return nil
+	}
+	p.last = time.Now()
+	elapsed := time.Since(p.started).Truncate(time.Second)
+	if p.size <= 0 {
+		p.log.Info("Dumping %s: %s after %s", p.name, formatBytes(n), elapsed)
+	} else {
+		p.log.Info("Dumping %s: %s of %s (%d%%) after %s", p.name, formatBytes(n), formatBytes(p.size), min(n*100/p.size, 100), elapsed)
+	}
+	return nil
+}
+
+// Finish and Exit log nothing; the outcome of the dump is logged anyway.
+func (p *progressLines) Finish() error { return nil }
+
+func (p *progressLines) Exit() error { return nil }
@@ -0,0 +1,399 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
+	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+)
+
+// DumpResult is the outcome of one mysqldump run (including its retries).
+type DumpResult struct {
+	// Name is the dump file name, e.g. shop.sql
+	Name      string
+	Databases []string
+	// Args are the mysqldump arguments from DumpFlagsList
+	Args []string
+	// EstimatedBytes is the size reported by the server before dumping
+	EstimatedBytes int64
+	// Bytes, Duration and Err describe the last attempt
+	Bytes    int64
+	Duration time.Duration
+	Attempts int
+	Err      error
+}
+
+// Status classifies the outcome of a run.
+type Status int
+
+const (
+	StatusSuccess Status = iota
+	// StatusPartialFailure means some, but not all, dumps failed
+	StatusPartialFailure
+	// StatusTotalFailure means every dump failed
+	StatusTotalFailure
+	// StatusCompressionFailure means the archive could not be written
+	StatusCompressionFailure
+	// StatusUploadFailure means the backup succeeded locally but an
+	// upload failed
+	StatusUploadFailure
+	// StatusOutputFailure means MANIFEST.json or RESTORE_ORDER.json could
+	// not be written
+	StatusOutputFailure
+)
+
+// Exit codes of the dump command. ExitError is used for failures before any
+// dump started, such as invalid options.
+const (
+	ExitSuccess            = 0
+	ExitError              = 1
+	ExitPartialFailure     = 2
+	ExitTotalFailure       = 3
+	ExitCompressionFailure = 4
+	ExitUploadFailure      = 5
+	ExitOutputFailure      = 6
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPartialFailure:
+		return "partial_failure"
+	case StatusTotalFailure:
+		return "total_failure"
+	case StatusCompressionFailure:
+		return "compression_failure"
+	case StatusUploadFailure:
+		return "upload_failure"
+	case StatusOutputFailure:
+		return "output_failure"
+	}
+	return "success"
+}
+
+// ExitCode returns the process exit code for s.
+func (s Status) ExitCode() int {
+	switch s {
+	case StatusPartialFailure:
+		return ExitPartialFailure
+	case StatusTotalFailure:
+		return ExitTotalFailure
+	case StatusCompressionFailure:
+		return ExitCompressionFailure
+	case StatusUploadFailure:
+		return ExitUploadFailure
+	case StatusOutputFailure:
+		return ExitOutputFailure
+	}
+	return ExitSuccess
+}
+
+// RunError is returned by Run when the backup did not fully succeed.
+type RunError struct {
+	Status Status
+	Errors []string
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("backup finished with %s: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// ExitCode returns the exit code for an error returned by Prepare or Run.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.Status.ExitCode()
+	}
+	return ExitError
+}
+
+// Status returns the outcome of the run. When several things failed, the
+// most severe one wins: no dump at all, a broken archive, the manifest or the
+// restore order, some dumps, uploads.
+func (r *Runner) Status() Status {
+	switch {
+	case len(r.Results) > 0 && r.failures == len(r.Results):
+		return StatusTotalFailure
+	case r.compressErr != nil:
+		return StatusCompressionFailure
+	case r.outputErr != nil:
+		return StatusOutputFailure
+	case r.failures > 0:
+		return StatusPartialFailure
+	case len(r.uploadErrs) > 0:
+		return StatusUploadFailure
+	}
+	return StatusSuccess
+}
+
+// Err returns nil if the run succeeded and a *RunError otherwise.
+func (r *Runner) Err() error {
+	status := r.Status()
+	if status == StatusSuccess {
+		return nil
+	}
+	return &RunError{Status: status, Errors: r.errors()}
+}
+
+// Report is the machine readable description of a run sent to webhooks.
+type Report struct {
+	RunID           string    `json:"run_id"`
+	Host            string    `json:"host"`
+	Status          string    `json:"status"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	OutputPath      string    `json:"output_path"`
+	// CompressedBytes is the size of the compressed dump files, 0 without
+	// compression
+	CompressedBytes int64        `json:"compressed_bytes,omitempty"`
+	Dumps           []DumpReport `json:"dumps"`
+	Files           []FileReport `json:"files"`
+	Errors          []string     `json:"errors,omitempty"`
+}
+
+// DumpReport describes one dump in a Report.
+type DumpReport struct {
+	Name            string   `json:"name"`
+	Databases       []string `json:"databases"`
+	Args            []string `json:"args"`
+	Status          string   `json:"status"`
+	EstimatedBytes  int64    `json:"estimated_bytes"`
+	Bytes           int64    `json:"bytes"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Attempts        int      `json:"attempts"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// FileReport describes one output file in a Report.
+type FileReport struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// notify sends the --notify email and --webhook reports for the finished run.
+func (r *Runner) notify() {
+	if (r.mailer == nil && len(r.webhooks) == 0) || r.Opts.DryRun {
+		return
+	}
+	if r.Opts.NotifyOnFailure && r.Status() == StatusSuccess {
+		return
+	}
+	subject, text := r.summarySubject(), r.summary()
+	if r.mailer != nil {
+		r.log.Info("Sending notification to %s", r.mailer)
+		if err := r.mailer.Send(subject, text); err != nil {
+			r.log.Error("Failed to send notification: %v", err)
+		}
+	}
+	if len(r.webhooks) == 0 {
+		return
+	}
+	report := r.Report()
+	for _, w := range r.webhooks {
+		r.log.Info("Sending report to webhook %s", w)
+		if err := w.Send(context.Background(), report, subject, text); err != nil {
+			r.log.Error("Webhook %s failed: %v", w, err)
+		}
+	}
+}
+
+// Report returns the machine readable report of the run, including the
+// SHA-256 of every output file. Files not hashed for the manifest are hashed
+// here, so the report of a finished run is only built once, on first use.
+func (r *Runner) Report() *Report {
+	if r.report != nil {
+		return r.report
+	}
+	hostname, _ := os.Hostname()
+	rep := &Report{
+		RunID:           r.RunID,
+		Host:            hostname,
+		Status:          r.Status().String(),
+		Started:         r.Started,
+		Finished:        r.Finished,
+		DurationSeconds: r.Finished.Sub(r.Started).Seconds(),
+		OutputPath:      r.Opts.OutputPath,
+		CompressedBytes: r.compressedBytes,
+		Dumps:           []DumpReport{},
+		Files:           []FileReport{},
+		Errors:          r.errors(),
+	}
+	for _, res := range r.Results {
+		d := DumpReport{
+			Name:            res.Name,
+			Databases:       res.Databases,
+			Args:            mysqlutil.RedactArgs(res.Args),
+			Status:          "success",
+			EstimatedBytes:  res.EstimatedBytes,
+			Bytes:           res.Bytes,
+			DurationSeconds: res.Duration.Seconds(),
+			Attempts:        res.Attempts,
+		}
+		if res.Err != nil {
+			d.Status = "failed"
+			d.Error = logging.Redact(res.Err.Error())
+		}
+		rep.Dumps = append(rep.Dumps, d)
+	}
+	for _, f := range r.OutputFiles {
+		fr := FileReport{Path: f}
+		if fi, err := os.Stat(f); err == nil {
+			fr.Size = fi.Size()
+			if sum, ok := r.checksums[f]; ok {
+				fr.SHA256 = sum
+			} else if _, sum, err := manifest.HashFile(f); err == nil {
+				fr.SHA256 = sum
+			} else {
+				r.log.Warn("Cannot checksum %s: %v", f, err)
+			}
+		}
+		rep.Files = append(rep.Files, fr)
+	}
+	if !r.Finished.IsZero() {
+		r.report = rep
+	}
+	return rep
+}
+
+// errors returns all failures of the run as messages.
+func (r *Runner) errors() []string {
+	var errs []string
+	for _, res := range r.Results {
+		if res.Err != nil {
+			errs = append(errs, fmt.Sprintf("%s (after %d attempts): %v", res.Name, res.Attempts, res.Err))
+		}
+	}
+	for _, err := range r.uploadErrs {
+		errs = append(errs, err.Error())
+	}
+	if r.compressErr != nil {
+		errs = append(errs, fmt.Sprintf("compression: %v", r.compressErr))
+	}
+	if r.outputErr != nil {
+		errs = append(errs, fmt.Sprintf("output: %v", r.outputErr))
+	}
+	for i := range errs {
+		errs[i] = logging.Redact(errs[i])
+	}
+	return errs
+}
+
+func (r *Runner) summarySubject() string {
+	hostname, _ := os.Hostname()
+	status := "succeeded"
+	if st := r.Status(); st != StatusSuccess {
+		status = fmt.Sprintf("FAILED (%s)", st)
+	}
+	return fmt.Sprintf("[mymagicdump] Backup %s on %s (%d/%d dumps ok)", status, hostname, len(r.Results)-r.failures, len(r.Results))
+}
+
+// printSummary prints the summary of the run to stdout, or to stderr when
+// stdout carries the --events stream. With --silent it is only printed, to
+// stderr, when the run failed, and it is never printed when Headless.
+func (r *Runner) printSummary() {
+	if r.Headless {
+		return
+	}
+	if !r.Opts.Silent {
+		out := os.Stdout
+		if r.EventOutput == os.Stdout {
+			out = os.Stderr
+		}
+		fmt.Fprintf(out, "\n%s", r.summary())
+	} else if r.Status() != StatusSuccess {
+		fmt.Fprint(os.Stderr, r.summary())
+	}
+}
+
+// summary returns a plain text report of the run.
+func (r *Runner) summary() string {
+	var b strings.Builder
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&b, "Status:   %s\n", r.Status())
+	fmt.Fprintf(&b, "Host:     %s\n", hostname)
+	fmt.Fprintf(&b, "Started:  %s\n", r.Started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Finished: %s (%s)\n", r.Finished.Format(time.RFC3339), r.Finished.Sub(r.Started).Round(time.Second))
+	fmt.Fprintf(&b, "Output:   %s\n\n", r.Opts.OutputPath)
+
+	b.WriteString("Dumps:\n")
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tDATABASES\tSTATUS\tSIZE\tESTIMATED\tDURATION\tATTEMPTS")
+	for _, res := range r.Results {
+		status := "ok"
+		if res.Err != nil {
+			status = "FAILED"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%d\n", res.Name, strings.Join(res.Databases, ","), status,
+			formatBytes(res.Bytes), formatBytes(res.EstimatedBytes), res.Duration.Round(time.Second), res.Attempts)
+	}
+	tw.Flush()
+
+	if len(r.OutputFiles) > 0 {
+		b.WriteString("\nFiles:\n")
+		tw = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		for _, f := range r.OutputFiles {
+			size := "-"
+			if fi, err := os.Stat(f); err == nil {
+				size = formatBytes(fi.Size())
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", f, size)
+		}
+		tw.Flush()
+	}
+
+	if errs := r.errors(); len(errs) > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, e := range errs {
+			fmt.Fprintf(&b, "  - %s\n", e)
+		}
+	}
+	return b.String()
+}
+
+// formatBytes formats n with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,94 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package dumper
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/retention"
+	"github.com/trustservers-hosting/mymagicdump/internal/upload"
+)
+
+// retentionPolicy returns the --keep-* options as a retention policy.
+func (r *Runner) retentionPolicy() retention.Policy {
+	return retention.Policy{
+		KeepLast: r.Opts.KeepLast,
+		Daily:    r.Opts.KeepDaily,
+		Weekly:   r.Opts.KeepWeekly,
+		Monthly:  r.Opts.KeepMonthly,
+		Job:      fileName(r.Opts.Job),
+	}
+}
+
+// applyRetention prunes old run directories from the output directory and
+// from the given destinations. It is only called after a successful backup,
+// and only with destinations that received the whole run.
+func (r *Runner) applyRetention(dests []upload.Destination) {
+	policy := r.retentionPolicy()
+	if !policy.Enabled() {
+		return
+	}
+	entries, err := os.ReadDir(r.outputRoot)
+	if err != nil {
+		r.log.Warn("Cannot list %s for retention: %v", r.outputRoot, err)
+	} else {
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+		for _, name := range r.prunable(policy, names) {
+			dir := filepath.Join(r.outputRoot, name)
+			r.log.Info("Removing old run %s", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				r.log.Warn("Failed to remove %s: %v", dir, err)
+			}
+		}
+	}
+
+	ctx := r.ctx
+	for _, dest := range dests {
+		names, err := dest.List(ctx)
+		if err != nil {
+			r.log.Warn("Cannot list %s for retention: %v", dest, err)
+			continue
+		}
+		for _, name := range r.prunable(policy, names) {
+			r.log.Info("Removing old run %s from %s", name, dest)
+			if err := dest.Remove(ctx, name); err != nil {
+				r.log.Warn("Failed to remove %s from %s: %v", name, dest, err)
+			}
+		}
+	}
+}
+
+// prunable returns the runs in names the policy drops, never the current one.
+func (r *Runner) prunable(policy retention.Policy, names []string) []string {
+	return slices.DeleteFunc(policy.Prune(names), func(name string) bool {
+		return name == r.runDir
+	})
+}
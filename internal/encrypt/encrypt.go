@@ -0,0 +1,124 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// Ext is appended to the name of every encrypted file.
+const Ext = ".age"
+
+// Recipients returns the age recipients to encrypt to: X25519 recipients given
+// on the command line or in a recipients file, or a single passphrase recipient.
+func Recipients(recipients []string, recipientsFile, passphraseFile string) ([]age.Recipient, error) {
+	var out []age.Recipient
+	for _, r := range recipients {
+		parsed, err := age.ParseRecipients(strings.NewReader(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", r, err)
+		}
+		out = append(out, parsed...)
+	}
+	if recipientsFile != "" {
+		data, err := os.ReadFile(config.ExpandTilde(recipientsFile))
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseRecipients(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("recipients file %s: %w", recipientsFile, err)
+		}
+		out = append(out, parsed...)
+	}
+	if passphraseFile != "" {
+		// age only allows a passphrase as the sole recipient
+		if len(out) > 0 {
+			return nil, fmt.Errorf("a passphrase cannot be combined with other recipients")
+		}
+		passphrase, err := readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("encryption needs at least one recipient or a passphrase")
+	}
+	return out, nil
+}
+
+// Identities returns the age identities used to decrypt, read from identity
+// files (as written by age-keygen) and/or a passphrase file.
+func Identities(identityFiles []string, passphraseFile string) ([]age.Identity, error) {
+	var out []age.Identity
+	for _, f := range identityFiles {
+		data, err := os.ReadFile(config.ExpandTilde(f))
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("identity file %s: %w", f, err)
+		}
+		out = append(out, parsed...)
+	}
+	if passphraseFile != "" {
+		passphrase, err := readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// readPassphrase reads the first line of a passphrase file.
+func readPassphrase(file string) (string, error) {
+	data, err := os.ReadFile(config.ExpandTilde(file))
+	if err != nil {
+		return "", err
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file %s is empty", file)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,122 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package engine produces the SQL of a dump, either by running mysqldump or
+// natively over the MySQL protocol.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// Names of the engines selectable with --engine.
+const (
+	Mysqldump = "mysqldump"
+	Native    = "native"
+)
+
+// Engine runs a single dump. A dump is described by mysqldump options and
+// arguments without the connection options, e.g.
+// [--no-data --databases shop], so that every engine understands the same
+// dump plans.
+type Engine interface {
+	// Dump writes the SQL of the dump described by args to w. Diagnostics
+	// are written to errOut.
+	Dump(ctx context.Context, args []string, w, errOut io.Writer) error
+	// Command returns the command line Dump runs for args, for logs.
+	Command(args []string) []string
+	// Close releases the resources of the engine.
+	Close() error
+}
+
+// Snapshotter is implemented by engines whose dumps can share transactions
+// started at one moment. Snapshot is called while a global read lock is held,
+// which is released once it returns, so that all dumps read the same data
+// without blocking writes for the whole run.
+type Snapshotter interface {
+	// Snapshot prepares count transactions for the dumps that follow,
+	// count of which may run at the same time.
+	Snapshot(ctx context.Context, count int) error
+}
+
+// New returns the engine called name.
+func New(name string, opts config.ConnectionOptions, connFlags []string) (Engine, error) {
+	switch name {
+	case Mysqldump:
+		return NewExec(connFlags)
+	case Native:
+		return NewNative(opts)
+	}
+	return nil, fmt.Errorf("unknown dump engine %q", name)
+}
+
+// Exec runs mysqldump, or mariadb-dump when mysqldump is not installed.
+type Exec struct {
+	binary    string
+	connFlags []string
+}
+
+// NewExec returns an engine running mysqldump with the given connection flags.
+func NewExec(connFlags []string) (*Exec, error) {
+	binary, err := resolveDumpBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &Exec{binary: binary, connFlags: connFlags}, nil
+}
+
+func (e *Exec) Dump(ctx context.Context, args []string, w, errOut io.Writer) error {
+	cmd := exec.CommandContext(ctx, e.binary, append(append([]string{}, e.connFlags...), args...)...)
+	cmd.Stdout = w
+	cmd.Stderr = errOut
+	return cmd.Run()
+}
+
+func (e *Exec) Command(args []string) []string {
+	return append(append([]string{e.binary}, e.connFlags...), args...)
+}
+
+func (e *Exec) Close() error {
+	return nil
+}
+
+func (e *Exec) String() string {
+	return filepath.Base(e.binary)
+}
+
+// resolveDumpBinary returns the path to mysqldump or mariadb-dump.
+func resolveDumpBinary() (string, error) {
+	if path, err := exec.LookPath("mysqldump"); err == nil {
+		return path, nil
+	}
+	if path, err := exec.LookPath("mariadb-dump"); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("cannot find mysqldump or mariadb-dump in PATH")
+}
@@ -0,0 +1,740 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+)
+
+// NativeEngine dumps over the MySQL protocol with go-sql-driver, so neither
+// mysqldump nor the mysql client is needed. Every dump runs in its own
+// START TRANSACTION WITH CONSISTENT SNAPSHOT, like mysqldump
+// --single-transaction, unless Snapshot started shared ones, and its output follows mysqldump's layout: CREATE
+// TABLE, extended INSERTs, views, triggers, routines and events.
+type NativeEngine struct {
+	db *sql.DB
+	// snapshots holds the idle connections started by Snapshot; conns are
+	// all of them
+	snapshots chan *sql.Conn
+	conns     []*sql.Conn
+}
+
+// NewNative returns a native engine for the server of the connection options.
+func NewNative(opts config.ConnectionOptions) (*NativeEngine, error) {
+	db, err := mysqlutil.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &NativeEngine{db: db}, nil
+}
+
+func (n *NativeEngine) Dump(ctx context.Context, args []string, w, errOut io.Writer) error {
+	req, ignored := parseDumpArgs(args)
+	for _, arg := range ignored {
+		fmt.Fprintf(errOut, "mymagicdump: the native engine ignores %s\n", arg)
+	}
+	var conn *sql.Conn
+	if n.snapshots != nil {
+		select {
+		case conn = <-n.snapshots:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { n.snapshots <- conn }()
+	} else {
+		var err error
+		if conn, err = n.begin(ctx); err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer conn.ExecContext(context.Background(), "ROLLBACK")
+	}
+
+	bw := bufio.NewWriterSize(w, 64*1024)
+	d := &nativeDump{ctx: ctx, conn: conn, w: bw, req: req}
+	if err := d.run(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Snapshot starts count transactions that all later dumps share, count at a
+// time, instead of starting one per dump. When it is called under a global
+// read lock, every dump reads the data of that moment and the lock can be
+// released as soon as it returns. As with mysqldump --single-transaction,
+// only transactional tables are covered.
+func (n *NativeEngine) Snapshot(ctx context.Context, count int) error {
+	n.snapshots = make(chan *sql.Conn, count)
+	for range count {
+		conn, err := n.begin(ctx)
+		if err != nil {
+			return err
+		}
+		n.conns = append(n.conns, conn)
+		n.snapshots <- conn
+	}
+	return nil
+}
+
+// begin opens a connection and starts a consistent snapshot transaction in it.
+func (n *NativeEngine) begin(ctx context.Context) (*sql.Conn, error) {
+	conn, err := n.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range []string{
+		"SET SESSION sql_mode = ''",
+		"SET SQL_QUOTE_SHOW_CREATE = 1",
+		"SET NAMES utf8mb4",
+		"SET time_zone = '+00:00'",
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT",
+	} {
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	return conn, nil
+}
+
+func (n *NativeEngine) Command(args []string) []string {
+	return append([]string{"native-dump"}, args...)
+}
+
+func (n *NativeEngine) Close() error {
+	for _, conn := range n.conns {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		conn.Close()
+	}
+	return n.db.Close()
+}
+
+func (n *NativeEngine) String() string {
+	return Native
+}
+
+// dumpRequest is the dump selected by the mysqldump options the native
+// engine understands.
+type dumpRequest struct {
+	// databases are dumped with CREATE DATABASE and USE statements when
+	// useDatabases is set (--databases, --all-databases); otherwise the
+	// first argument is the database and the rest are its tables
+	databases       []string
+	tables          []string
+	allDatabases    bool
+	useDatabases    bool
+	ignore          []string
+	where           string
+	noData          bool
+	noCreateInfo    bool
+	noCreateDB      bool
+	addDropTable    bool
+	addDropDatabase bool
+	triggers        bool
+	routines        bool
+	events          bool
+}
+
+// acceptedOptions are mysqldump options that do not change what the native
+// engine writes, because it always behaves that way.
+var acceptedOptions = []string{
+	"--single-transaction", "--quick", "-q", "--opt", "--extended-insert", "-e",
+	"--lock-tables", "--skip-lock-tables", "--add-locks", "--disable-keys",
+	"--hex-blob", "--tz-utc", "--create-options", "--set-charset", "--comments",
+}
+
+// parseDumpArgs reads mysqldump options and arguments. It returns the
+// options it does not support; they are ignored.
+func parseDumpArgs(args []string) (dumpRequest, []string) {
+	req := dumpRequest{addDropTable: true, triggers: true}
+	var positional, ignored []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			positional = append(positional, arg)
+		case arg == "--databases" || arg == "-B":
+			req.useDatabases = true
+		case arg == "--all-databases" || arg == "-A":
+			req.allDatabases, req.useDatabases = true, true
+		case name == "--ignore-table" && hasValue:
+			req.ignore = append(req.ignore, value)
+		case name == "--where" || name == "-w":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			req.where = value
+		case arg == "--no-data" || arg == "-d":
+			req.noData = true
+		case arg == "--no-create-info" || arg == "-t":
+			req.noCreateInfo = true
+		case arg == "--no-create-db" || arg == "-n":
+			req.noCreateDB = true
+		case arg == "--add-drop-table":
+			req.addDropTable = true
+		case arg == "--skip-add-drop-table":
+			req.addDropTable = false
+		case arg == "--add-drop-database":
+			req.addDropDatabase = true
+		case arg == "--triggers":
+			req.triggers = true
+		case arg == "--skip-triggers":
+			req.triggers = false
+		case arg == "--routines" || arg == "-R":
+			req.routines = true
+		case arg == "--skip-routines":
+			req.routines = false
+		case arg == "--events" || arg == "-E":
+			req.events = true
+		case arg == "--skip-events":
+			req.events = false
+		case slices.Contains(acceptedOptions, name):
+		default:
+			ignored = append(ignored, arg)
+		}
+	}
+	switch {
+	case req.allDatabases:
+	case req.useDatabases:
+		req.databases = positional
+	case len(positional) > 0:
+		req.databases, req.tables = positional[:1], positional[1:]
+	}
+	return req, ignored
+}
+
+// nativeDump writes one dump from a connection inside a consistent snapshot.
+type nativeDump struct {
+	ctx  context.Context
+	conn *sql.Conn
+	w    *bufio.Writer
+	req  dumpRequest
+}
+
+// maxStatement is the size at which an extended INSERT is ended and a new one
+// started, like mysqldump's default net_buffer_length.
+const maxStatement = 1024 * 1024
+
+func (d *nativeDump) run() error {
+	databases := d.req.databases
+	if d.req.allDatabases {
+		all, err := d.column("SHOW DATABASES")
+		if err != nil {
+			return err
+		}
+		databases = slices.DeleteFunc(all, func(db string) bool {
+			return slices.Contains([]string{"information_schema", "performance_schema", "sys"}, db)
+		})
+	}
+	if len(databases) == 0 {
+		return errors.New("no database to dump")
+	}
+	var version string
+	if err := d.conn.QueryRowContext(d.ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return err
+	}
+	d.header(version, databases)
+	for _, db := range databases {
+		if err := d.database(db); err != nil {
+			return err
+		}
+	}
+	d.footer()
+	return nil
+}
+
+func (d *nativeDump) header(version string, databases []string) {
+	database := ""
+	if len(databases) == 1 {
+		database = databases[0]
+	}
+	fmt.Fprintf(d.w, "-- mymagicdump native dump\n--\n-- Database: %s\n", database)
+	fmt.Fprintf(d.w, "-- ------------------------------------------------------\n-- Server version\t%s\n\n", version)
+	d.w.WriteString(`/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!50503 SET NAMES utf8mb4 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+`)
+}
+
+func (d *nativeDump) footer() {
+	d.w.WriteString(`/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
+
+`)
+	fmt.Fprintf(d.w, "-- Dump completed on %s\n", time.Now().Format(time.DateTime))
+}
+
+// database dumps one database: its tables with their data and triggers,
+// then its events, routines and views.
+func (d *nativeDump) database(db string) error {
+	qdb := mysqlutil.QuoteIdent(db)
+	if d.req.useDatabases {
+		fmt.Fprintf(d.w, "\n--\n-- Current Database: %s\n--\n\n", qdb)
+		if !d.req.noCreateDB {
+			if d.req.addDropDatabase {
+				fmt.Fprintf(d.w, "/*!40000 DROP DATABASE IF EXISTS %s*/;\n\n", qdb)
+			}
+			row, err := d.row("SHOW CREATE DATABASE " + qdb)
+			if err != nil {
+				return err
+			}
+			create := strings.Replace(row["Create Database"], "CREATE DATABASE ", "CREATE DATABASE /*!32312 IF NOT EXISTS*/ ", 1)
+			fmt.Fprintf(d.w, "%s;\n", create)
+		}
+		fmt.Fprintf(d.w, "\nUSE %s;\n", qdb)
+	}
+	// Select the database like mysqldump does; some statements rely on it
+	if _, err := d.conn.ExecContext(d.ctx, "USE "+qdb); err != nil {
+		return err
+	}
+	tables, views, err := d.tables(db)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if !d.req.noCreateInfo {
+			if err := d.tableStructure(db, t); err != nil {
+				return err
+			}
+		}
+		if !d.req.noData {
+			if err := d.tableData(db, t); err != nil {
+				return err
+			}
+		}
+		if d.req.triggers {
+			if err := d.tableTriggers(db, t); err != nil {
+				return err
+			}
+		}
+	}
+	if d.req.events {
+		if err := d.events(db); err != nil {
+			return err
+		}
+	}
+	if d.req.routines {
+		if err := d.routines(db); err != nil {
+			return err
+		}
+	}
+	if !d.req.noCreateInfo {
+		for _, v := range views {
+			if err := d.viewPlaceholder(db, v); err != nil {
+				return err
+			}
+		}
+		for _, v := range views {
+			if err := d.view(db, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tables returns the base tables and views of db that are dumped.
+func (d *nativeDump) tables(db string) (tables, views []string, err error) {
+	rows, err := d.conn.QueryContext(d.ctx, "SHOW FULL TABLES FROM "+mysqlutil.QuoteIdent(db))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, nil, err
+		}
+		if slices.Contains(d.req.ignore, db+"."+name) {
+			continue
+		}
+		if len(d.req.tables) > 0 && !slices.Contains(d.req.tables, name) {
+			continue
+		}
+		if kind == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, t := range d.req.tables {
+		if !slices.Contains(tables, t) && !slices.Contains(views, t) {
+			return nil, nil, fmt.Errorf("couldn't find table: %q", t)
+		}
+	}
+	return tables, views, nil
+}
+
+func (d *nativeDump) tableStructure(db, table string) error {
+	qt := mysqlutil.QuoteIdent(table)
+	row, err := d.row("SHOW CREATE TABLE " + mysqlutil.QuoteIdent(db) + "." + qt)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.w, "\n--\n-- Table structure for table %s\n--\n\n", qt)
+	if d.req.addDropTable {
+		fmt.Fprintf(d.w, "DROP TABLE IF EXISTS %s;\n", qt)
+	}
+	fmt.Fprintf(d.w, "/*!40101 SET @saved_cs_client     = @@character_set_client */;\n")
+	fmt.Fprintf(d.w, "/*!50503 SET character_set_client = utf8mb4 */;\n")
+	fmt.Fprintf(d.w, "%s;\n", row["Create Table"])
+	fmt.Fprintf(d.w, "/*!40101 SET character_set_client = @saved_cs_client */;\n")
+	return nil
+}
+
+// generatedColumn reports whether the EXTRA of a column marks it as
+// generated. MySQL 8 also reports DEFAULT_GENERATED for columns with an
+// expression default such as CURRENT_TIMESTAMP, whose values are dumped.
+func generatedColumn(extra string) bool {
+	extra = strings.ToUpper(extra)
+	for _, kind := range []string{"VIRTUAL GENERATED", "STORED GENERATED", "PERSISTENT GENERATED"} {
+		if strings.Contains(extra, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableData writes the rows of a table as extended INSERT statements. The
+// columns are listed explicitly, as SELECT * skips invisible columns.
+// Generated columns are left out, as the server computes them; the INSERTs
+// name their columns when a column is left out or invisible.
+func (d *nativeDump) tableData(db, table string) error {
+	qt := mysqlutil.QuoteIdent(table)
+	rows, err := d.conn.QueryContext(d.ctx,
+		"SELECT COLUMN_NAME, EXTRA FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		db, table)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	named := false
+	for rows.Next() {
+		var name string
+		var extra sql.NullString
+		if err := rows.Scan(&name, &extra); err != nil {
+			rows.Close()
+			return err
+		}
+		if generatedColumn(extra.String) {
+			named = true
+			continue
+		}
+		if strings.Contains(strings.ToUpper(extra.String), "INVISIBLE") {
+			named = true
+		}
+		columns = append(columns, mysqlutil.QuoteIdent(name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		// Only generated columns: nothing to restore
+		return nil
+	}
+	selectList := strings.Join(columns, ",")
+	insert := "INSERT INTO " + qt + " VALUES "
+	if named {
+		insert = "INSERT INTO " + qt + " (" + selectList + ") VALUES "
+	}
+	query := "SELECT " + selectList + " FROM " + mysqlutil.QuoteIdent(db) + "." + qt
+	if d.req.where != "" {
+		query += " WHERE " + d.req.where
+	}
+
+	fmt.Fprintf(d.w, "\n--\n-- Dumping data for table %s\n--\n\n", qt)
+	fmt.Fprintf(d.w, "LOCK TABLES %s WRITE;\n/*!40000 ALTER TABLE %s DISABLE KEYS */;\n", qt, qt)
+	rows, err = d.conn.QueryContext(d.ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	kinds := make([]valueKind, len(types))
+	for i, t := range types {
+		kinds[i] = kindOf(t.DatabaseTypeName())
+	}
+	values := make([]sql.RawBytes, len(types))
+	dest := make([]any, len(types))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	stmt := make([]byte, 0, maxStatement+64*1024)
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if len(stmt) == 0 {
+			stmt = append(stmt, insert...)
+		} else {
+			stmt = append(stmt, ',')
+		}
+		stmt = append(stmt, '(')
+		for i, v := range values {
+			if i > 0 {
+				stmt = append(stmt, ',')
+			}
+			stmt = appendValue(stmt, v, kinds[i])
+		}
+		stmt = append(stmt, ')')
+		if len(stmt) >= maxStatement {
+			if _, err := d.w.Write(append(stmt, ";\n"...)); err != nil {
+				return err
+			}
+			stmt = stmt[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(stmt) > 0 {
+		if _, err := d.w.Write(append(stmt, ";\n"...)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(d.w, "/*!40000 ALTER TABLE %s ENABLE KEYS */;\nUNLOCK TABLES;\n", qt)
+	return nil
+}
+
+func (d *nativeDump) tableTriggers(db, table string) error {
+	names, err := d.column("SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = ? AND EVENT_OBJECT_TABLE = ? ORDER BY ACTION_ORDER", db, table)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	for _, name := range names {
+		row, err := d.row("SHOW CREATE TRIGGER " + mysqlutil.QuoteIdent(db) + "." + mysqlutil.QuoteIdent(name))
+		if err != nil {
+			return err
+		}
+		d.compound(row, "", row["SQL Original Statement"])
+	}
+	return nil
+}
+
+func (d *nativeDump) events(db string) error {
+	names, err := d.column("SELECT EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY EVENT_NAME", db)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	fmt.Fprintf(d.w, "\n--\n-- Dumping events for database '%s'\n--\n", db)
+	for _, name := range names {
+		qn := mysqlutil.QuoteIdent(name)
+		row, err := d.row("SHOW CREATE EVENT " + mysqlutil.QuoteIdent(db) + "." + qn)
+		if err != nil {
+			return err
+		}
+		d.compound(row, "/*!50106 DROP EVENT IF EXISTS "+qn+" */;\n", row["Create Event"])
+	}
+	return nil
+}
+
+func (d *nativeDump) routines(db string) error {
+	rows, err := d.conn.QueryContext(d.ctx, "SELECT ROUTINE_NAME, ROUTINE_TYPE FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? ORDER BY ROUTINE_TYPE, ROUTINE_NAME", db)
+	if err != nil {
+		return err
+	}
+	type routine struct{ name, kind string }
+	var routines []routine
+	for rows.Next() {
+		var r routine
+		if err := rows.Scan(&r.name, &r.kind); err != nil {
+			rows.Close()
+			return err
+		}
+		routines = append(routines, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(routines) == 0 {
+		return err
+	}
+	fmt.Fprintf(d.w, "\n--\n-- Dumping routines for database '%s'\n--\n", db)
+	for _, r := range routines {
+		qn := mysqlutil.QuoteIdent(r.name)
+		row, err := d.row("SHOW CREATE " + r.kind + " " + mysqlutil.QuoteIdent(db) + "." + qn)
+		if err != nil {
+			return err
+		}
+		create := row["Create Procedure"]
+		if r.kind == "FUNCTION" {
+			create = row["Create Function"]
+		}
+		if create == "" {
+			return fmt.Errorf("no permission to read the definition of %s %s.%s", strings.ToLower(r.kind), db, r.name)
+		}
+		d.compound(row, "/*!50003 DROP "+r.kind+" IF EXISTS "+qn+" */;\n", create)
+	}
+	return nil
+}
+
+// compound writes a trigger, routine or event definition with the sql_mode
+// and character set it was created with, as mysqldump does.
+func (d *nativeDump) compound(row map[string]string, drop, create string) {
+	d.w.WriteString(drop)
+	fmt.Fprintf(d.w, "/*!50003 SET @saved_cs_client      = @@character_set_client */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET @saved_cs_results     = @@character_set_results */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET @saved_col_connection = @@collation_connection */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET character_set_client  = %s */ ;\n", row["character_set_client"])
+	fmt.Fprintf(d.w, "/*!50003 SET character_set_results = %s */ ;\n", row["character_set_client"])
+	fmt.Fprintf(d.w, "/*!50003 SET collation_connection  = %s */ ;\n", row["collation_connection"])
+	fmt.Fprintf(d.w, "/*!50003 SET @saved_sql_mode       = @@sql_mode */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET sql_mode              = '%s' */ ;\n", row["sql_mode"])
+	if tz, ok := row["time_zone"]; ok {
+		fmt.Fprintf(d.w, "/*!50003 SET @saved_time_zone      = @@time_zone */ ;\n")
+		fmt.Fprintf(d.w, "/*!50003 SET time_zone             = '%s' */ ;\n", tz)
+	}
+	fmt.Fprintf(d.w, "DELIMITER ;;\n%s ;;\nDELIMITER ;\n", create)
+	if _, ok := row["time_zone"]; ok {
+		fmt.Fprintf(d.w, "/*!50003 SET time_zone             = @saved_time_zone */ ;\n")
+	}
+	fmt.Fprintf(d.w, "/*!50003 SET sql_mode              = @saved_sql_mode */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET character_set_client  = @saved_cs_client */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET character_set_results = @saved_cs_results */ ;\n")
+	fmt.Fprintf(d.w, "/*!50003 SET collation_connection  = @saved_col_connection */ ;\n")
+}
+
+// viewPlaceholder writes a view with the columns of the real one, so that
+// views using other views can be created before those are final.
+func (d *nativeDump) viewPlaceholder(db, view string) error {
+	columns, err := d.column("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", db, view)
+	if err != nil {
+		return err
+	}
+	qv := mysqlutil.QuoteIdent(view)
+	selects := make([]string, len(columns))
+	for i, c := range columns {
+		selects[i] = " 1 AS " + mysqlutil.QuoteIdent(c)
+	}
+	fmt.Fprintf(d.w, "\n--\n-- Temporary view structure for view %s\n--\n\n", qv)
+	fmt.Fprintf(d.w, "DROP TABLE IF EXISTS %s;\n/*!50001 DROP VIEW IF EXISTS %s*/;\n", qv, qv)
+	fmt.Fprintf(d.w, "SET @saved_cs_client     = @@character_set_client;\n/*!50503 SET character_set_client = utf8mb4 */;\n")
+	fmt.Fprintf(d.w, "/*!50001 CREATE VIEW %s AS SELECT \n%s*/;\n", qv, strings.Join(selects, ",\n"))
+	fmt.Fprintf(d.w, "SET character_set_client = @saved_cs_client;\n")
+	return nil
+}
+
+func (d *nativeDump) view(db, view string) error {
+	qv := mysqlutil.QuoteIdent(view)
+	row, err := d.row("SHOW CREATE VIEW " + mysqlutil.QuoteIdent(db) + "." + qv)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.w, "\n--\n-- Final view structure for view %s\n--\n\n", qv)
+	fmt.Fprintf(d.w, "/*!50001 DROP VIEW IF EXISTS %s*/;\n", qv)
+	fmt.Fprintf(d.w, "/*!50001 SET @saved_cs_client          = @@character_set_client */;\n")
+	fmt.Fprintf(d.w, "/*!50001 SET @saved_cs_results         = @@character_set_results */;\n")
+	fmt.Fprintf(d.w, "/*!50001 SET @saved_col_connection     = @@collation_connection */;\n")
+	fmt.Fprintf(d.w, "/*!50001 SET character_set_client      = %s */;\n", row["character_set_client"])
+	fmt.Fprintf(d.w, "/*!50001 SET character_set_results     = %s */;\n", row["character_set_client"])
+	fmt.Fprintf(d.w, "/*!50001 SET collation_connection      = %s */;\n", row["collation_connection"])
+	fmt.Fprintf(d.w, "/*!50001 %s */;\n", row["Create View"])
+	fmt.Fprintf(d.w, "/*!50001 SET character_set_client      = @saved_cs_client */;\n")
+	fmt.Fprintf(d.w, "/*!50001 SET character_set_results     = @saved_cs_results */;\n")
+	fmt.Fprintf(d.w, "/*!50001 SET collation_connection      = @saved_col_connection */;\n")
+	return nil
+}
+
+// row runs a query returning a single row and returns it by column name.
+func (d *nativeDump) row(query string, args ...any) (map[string]string, error) {
+	rows, err := d.conn.QueryContext(d.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s returned no rows", query)
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(columns))
+	for i, c := range columns {
+		row[c] = values[i].String
+	}
+	return row, nil
+}
+
+// column runs a query and returns the first column of every row.
+func (d *nativeDump) column(query string, args ...any) ([]string, error) {
+	rows, err := d.conn.QueryContext(d.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
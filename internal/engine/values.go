@@ -0,0 +1,81 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package engine
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// valueKind selects how a column value is written in an INSERT statement.
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindBinary
+)
+
+// kindOf returns the value kind of a column from its go-sql-driver type name.
+func kindOf(typeName string) valueKind {
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR":
+		return kindNumber
+	case "BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return kindBinary
+	}
+	return kindString
+}
+
+// appendValue appends a column value as an SQL literal: numbers as they are,
+// binary data as a hex literal, everything else as an escaped string.
+func appendValue(buf, v []byte, kind valueKind) []byte {
+	switch {
+	case v == nil:
+		return append(buf, "NULL"...)
+	case kind == kindNumber:
+		return append(buf, v...)
+	case kind == kindBinary && len(v) > 0:
+		buf = append(buf, "0x"...)
+		return hex.AppendEncode(buf, v)
+	}
+	buf = append(buf, '\'')
+	for _, c := range v {
+		switch c {
+		case 0:
+			buf = append(buf, '\\', '0')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\\', '\'', '"':
+			buf = append(buf, '\\', c)
+		case 0x1a:
+			buf = append(buf, '\\', 'Z')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '\'')
+}
@@ -23,34 +23,178 @@
 
 package logging
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
 
 var silent bool
 var verbose bool
 
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// Levels of log messages, as written in the json format.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warning"
+	LevelError = "error"
+)
+
 func SetVerbosity(silentMode bool, verboseMode bool) {
 	silent = silentMode
 	verbose = verboseMode
 }
 
+// SetOutput sets where log messages are written when they go to the
+// console, os.Stderr by default.
+func SetOutput(w io.Writer) {
+	log.SetOutput(w)
+}
+
+// AddSecret registers values, such as passwords, that are replaced by ***
+// wherever they appear in a log message or in a text passed to Redact.
+func AddSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if v != "" && !slices.Contains(secrets, v) {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact returns s with every registered secret replaced by ***.
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, "***")
+	}
+	return s
+}
+
+// Logger writes messages together with fields telling what they belong to,
+// such as the run, the job or the database. The fields are only written by
+// the json format and to journald.
+type Logger struct {
+	fields []Field
+	// hook also receives every message written
+	hook func(Entry)
+}
+
+// Field is a named value attached to the messages of a Logger.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// std is the Logger of the package level functions, without fields.
+var std = &Logger{}
+
+// With returns a Logger adding the given key and value pairs to every
+// message.
+func With(keysAndValues ...any) *Logger {
+	return std.With(keysAndValues...)
+}
+
+// With returns a copy of l with the given key and value pairs added; a key
+// l already has is replaced.
+func (l *Logger) With(keysAndValues ...any) *Logger {
+	fields := slices.Clone(l.fields)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		value := keysAndValues[i+1]
+		if j := slices.IndexFunc(fields, func(f Field) bool { return f.Key == key }); j >= 0 {
+			fields[j].Value = value
+		} else {
+			fields = append(fields, Field{Key: key, Value: value})
+		}
+	}
+	return &Logger{fields: fields, hook: l.hook}
+}
+
+// WithHook returns a copy of l that also passes every message it writes,
+// and those of the Loggers derived from it, to hook.
+func (l *Logger) WithHook(hook func(Entry)) *Logger {
+	return &Logger{fields: l.fields, hook: hook}
+}
+
+func (l *Logger) Info(format string, args ...any) {
+	if !silent {
+		l.output(LevelInfo, format, args...)
+	}
+}
+
+func (l *Logger) Warn(format string, args ...any) {
+	if !silent {
+		l.output(LevelWarn, format, args...)
+	}
+}
+
+func (l *Logger) Debug(format string, args ...any) {
+	if verbose && !silent {
+		l.output(LevelDebug, format, args...)
+	}
+}
+
+func (l *Logger) Error(format string, args ...any) {
+	l.output(LevelError, format, args...)
+}
+
 func Info(format string, args ...any) {
 	if !silent {
-		log.Printf("[INFO] "+format, args...)
+		std.output(LevelInfo, format, args...)
 	}
 }
 
 func Warn(format string, args ...any) {
 	if !silent {
-		log.Printf("[WARNING] "+format, args...)
+		std.output(LevelWarn, format, args...)
 	}
 }
 
 func Debug(format string, args ...any) {
 	if verbose && !silent {
-		log.Printf("[DEBUG] "+format, args...)
+		std.output(LevelDebug, format, args...)
 	}
 }
 
 func Error(format string, args ...any) {
-	log.Printf("[ERROR] "+format, args...)
+	std.output(LevelError, format, args...)
+}
+
+// Entry is one log message.
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Fields  []Field
+}
+
+func (l *Logger) output(level, format string, args ...any) {
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: Redact(fmt.Sprintf(format, args...)),
+		Fields:  l.fields,
+	}
+	if l.hook != nil {
+		l.hook(*e)
+	}
+	outputMu.Lock()
+	s := out
+	outputMu.Unlock()
+	if err := s.write(e); err != nil {
+		// The console is the last resort
+		log.Print(textLine(e))
+	}
 }
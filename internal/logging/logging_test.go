@@ -0,0 +1,48 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package logging
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	AddSecret("s3cret", "", "p@ss")
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "no secret", in: "connecting to db1", want: "connecting to db1"},
+		{name: "one secret", in: "password=s3cret", want: "password=***"},
+		{name: "repeated secret", in: "s3cret s3cret", want: "*** ***"},
+		{name: "several secrets", in: "-ps3cret --smtp-password=p@ss", want: "-p*** --smtp-password=***"},
+		{name: "prefix of a secret", in: "s3cre", want: "s3cre"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config selects the format and the destination of log messages. At most
+// one of File, Syslog and Journald may be set; without any of them messages
+// go to the console.
+type Config struct {
+	Format string
+	File   string
+	// MaxSize is the size in bytes at which File is rotated, and MaxFiles
+	// the number of rotated files kept
+	MaxSize  int64
+	MaxFiles int
+	Syslog   bool
+	Journald bool
+}
+
+// sink is a destination of log messages.
+type sink interface {
+	write(e *Entry) error
+	Close() error
+}
+
+var (
+	outputMu sync.Mutex
+	out      sink = &console{format: FormatText}
+)
+
+// Configure sets where and how messages are logged from now on. The
+// previous destination is closed.
+func Configure(c Config) error {
+	if c.Format == "" {
+		c.Format = FormatText
+	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("unknown log format %q", c.Format)
+	}
+	targets := 0
+	for _, set := range []bool{c.File != "", c.Syslog, c.Journald} {
+		if set {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return errors.New("only one of --log-file, --syslog and --journald can be used")
+	}
+	var s sink
+	var err error
+	switch {
+	case c.File != "":
+		s, err = newFileSink(c.File, c.Format, c.MaxSize, c.MaxFiles)
+	case c.Syslog:
+		s, err = newSyslog(c.Format)
+	case c.Journald:
+		s, err = newJournald()
+	default:
+		s = &console{format: c.Format}
+	}
+	if err != nil {
+		return err
+	}
+	outputMu.Lock()
+	prev := out
+	out = s
+	outputMu.Unlock()
+	return prev.Close()
+}
+
+// console writes messages to the output of the log package, which SetOutput
+// changes.
+type console struct {
+	format string
+}
+
+func (c *console) write(e *Entry) error {
+	if c.format == FormatJSON {
+		// log adds its own timestamp, which the json line already has
+		_, err := log.Writer().Write(jsonLine(e))
+		return err
+	}
+	log.Print(textLine(e))
+	return nil
+}
+
+func (c *console) Close() error { return nil }
+
+// textLine returns the message as it is written in the text format, without
+// the timestamp.
+func textLine(e *Entry) string {
+	return "[" + strings.ToUpper(e.Level) + "] " + e.Message
+}
+
+// jsonLine returns the message as one line of JSON: timestamp, level and msg
+// followed by the fields of the logger.
+func jsonLine(e *Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"timestamp":`)
+	writeJSON(&b, e.Time.Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, e.Level)
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(',')
+		writeJSON(&b, f.Key)
+		b.WriteByte(':')
+		writeJSON(&b, f.Value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func writeJSON(b *bytes.Buffer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
@@ -0,0 +1,189 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// identifier is the program name messages are logged under in syslog and
+// the journal.
+const identifier = "mymagicdump"
+
+// syslogSockets are the sockets of the local syslog daemon on Linux, BSD and
+// macOS.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// facilityDaemon is the syslog facility of system daemons.
+const facilityDaemon = 3
+
+// syslogSink sends messages to the local syslog daemon over its datagram
+// socket. In the json format the message is the JSON line.
+type syslogSink struct {
+	format string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslog(format string) (*syslogSink, error) {
+	s := &syslogSink{format: format}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				s.conn = conn
+				return nil
+			}
+		}
+	}
+	return errors.New("cannot connect to the syslog daemon")
+}
+
+func (s *syslogSink) write(e *Entry) error {
+	msg := textLine(e)
+	if s.format == FormatJSON {
+		msg = strings.TrimSuffix(string(jsonLine(e)), "\n")
+	}
+	// The local syslog format of the log/syslog package
+	line := fmt.Sprintf("<%d>%s %s[%d]: %s\n", facilityDaemon*8+severity(e.Level),
+		e.Time.Format(time.Stamp), identifier, os.Getpid(), msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+	}
+	// The daemon may have been restarted
+	if err := s.connect(); err != nil {
+		s.conn = nil
+		return err
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// severity returns the syslog severity of a level, which journald uses as
+// PRIORITY too.
+func severity(level string) int {
+	switch level {
+	case LevelError:
+		return 3
+	case LevelWarn:
+		return 4
+	case LevelDebug:
+		return 7
+	}
+	return 6
+}
+
+// journaldSocket is where journald receives messages in its native
+// protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends messages to the systemd journal. The fields of the
+// logger become journal fields, e.g. RUN_ID and DATABASE, which
+// journalctl can filter on.
+type journaldSink struct {
+	conn *net.UnixConn
+}
+
+func newJournald() (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to journald: %w", err)
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+func (s *journaldSink) write(e *Entry) error {
+	var b strings.Builder
+	journalField(&b, "MESSAGE", e.Message)
+	journalField(&b, "PRIORITY", strconv.Itoa(severity(e.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", identifier)
+	for _, f := range e.Fields {
+		journalField(&b, journalKey(f.Key), fmt.Sprint(f.Value))
+	}
+	_, err := s.conn.Write([]byte(b.String()))
+	return err
+}
+
+func (s *journaldSink) Close() error {
+	return s.conn.Close()
+}
+
+// journalField appends a field in the native protocol; values spanning
+// several lines are sent with their length.
+func journalField(b *strings.Builder, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	n := uint64(len(value))
+	for i := range 8 {
+		b.WriteByte(byte(n >> (8 * i)))
+	}
+	b.WriteString(value + "\n")
+}
+
+// journalKey returns a field name valid in the journal: upper case letters,
+// digits and underscores.
+func journalKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
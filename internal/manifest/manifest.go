@@ -0,0 +1,207 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// FileName is the name of the manifest in a run's output directory.
+	FileName = "MANIFEST.json"
+	// SumsFileName is the name of the optional sha256sum(1) compatible list.
+	SumsFileName = "SHA256SUMS"
+	// formatVersion is increased on incompatible changes of the manifest.
+	formatVersion = 1
+)
+
+// Manifest describes the files produced by one run.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	Tool          string    `json:"tool"`
+	Host          string    `json:"host"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	Started       time.Time `json:"started"`
+	Finished      time.Time `json:"finished"`
+	Dumps         []Dump    `json:"dumps"`
+	Files         []File    `json:"files"`
+}
+
+// Dump describes one mysqldump run. Args is the full command line with
+// passwords redacted.
+type Dump struct {
+	Name      string   `json:"name"`
+	Databases []string `json:"databases"`
+	Args      []string `json:"args"`
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// File is a produced file; Name is relative to the manifest's directory.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// New returns an empty manifest.
+func New(tool string) *Manifest {
+	hostname, _ := os.Hostname()
+	return &Manifest{FormatVersion: formatVersion, Tool: tool, Host: hostname, Dumps: []Dump{}, Files: []File{}}
+}
+
+// Write stores the manifest, and the SHA256SUMS file if sums is set, in dir.
+// It returns the paths of the written files. Each file is replaced
+// atomically.
+func (m *Manifest) Write(dir string, sums bool) ([]string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, FileName)
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return nil, err
+	}
+	written := []string{path}
+	if sums {
+		var b strings.Builder
+		for _, f := range m.Files {
+			// Two spaces select text mode in sha256sum -c, the common default
+			fmt.Fprintf(&b, "%s  %s\n", f.SHA256, f.Name)
+		}
+		path := filepath.Join(dir, SumsFileName)
+		if err := writeFileAtomic(path, []byte(b.String())); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// Read loads a manifest file.
+func Read(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if m.FormatVersion > formatVersion {
+		return nil, fmt.Errorf("%s has unsupported format version %d", path, m.FormatVersion)
+	}
+	return &m, nil
+}
+
+// ReadSums loads a SHA256SUMS file as manifest files without sizes (-1).
+func ReadSums(path string) ([]File, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var files []File
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s: malformed line %q", path, line)
+		}
+		// "*" marks binary mode
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		files = append(files, File{Name: name, Size: -1, SHA256: strings.ToLower(sum)})
+	}
+	return files, scanner.Err()
+}
+
+// HashFile returns the size and hex SHA-256 of the file at path.
+func HashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check re-hashes f relative to dir and describes the problem, if any.
+func Check(dir string, f File) error {
+	path := filepath.Join(dir, filepath.FromSlash(f.Name))
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("missing: %w", err)
+	}
+	if f.Size >= 0 && fi.Size() != f.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, found %d", f.Size, fi.Size())
+	}
+	_, sum, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	if sum != f.SHA256 {
+		return fmt.Errorf("checksum mismatch: expected %s, found %s", f.SHA256, sum)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
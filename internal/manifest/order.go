@@ -0,0 +1,91 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// OrderFileName is the name of the restore order of a per-table dump.
+const OrderFileName = "RESTORE_ORDER.json"
+
+// DatabaseFileName is the name of the file creating a database in its
+// directory of a per-table dump.
+const DatabaseFileName = "_database.sql"
+
+// Kinds of the files of a per-table dump, in the order a database is restored.
+const (
+	KindDatabase = "database"
+	KindSchema   = "schema"
+	KindData     = "data"
+	KindRoutines = "routines"
+	KindViews    = "views"
+	KindTriggers = "triggers"
+)
+
+// Order lists the files of a per-table dump in the order they have to be
+// restored: per database its CREATE DATABASE, the table definitions, the
+// table data, the routines and events, the views and finally the triggers,
+// so that no trigger fires while the data is loaded.
+type Order struct {
+	FormatVersion int `json:"format_version"`
+	// Snapshot is set when all files were dumped from one consistent snapshot
+	Snapshot bool   `json:"snapshot"`
+	Steps    []Step `json:"steps"`
+}
+
+// Step is one file of a per-table dump. File is relative to the dump's
+// directory, e.g. shop/orders_data.sql, and is replayed in Database.
+type Step struct {
+	File     string `json:"file"`
+	Database string `json:"database"`
+	Table    string `json:"table,omitempty"`
+	Kind     string `json:"kind"`
+}
+
+// NewOrder returns an empty restore order.
+func NewOrder(snapshot bool) *Order {
+	return &Order{FormatVersion: formatVersion, Snapshot: snapshot, Steps: []Step{}}
+}
+
+// Encode writes the restore order as indented JSON.
+func (o *Order) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(o)
+}
+
+// ReadOrder parses a restore order.
+func ReadOrder(r io.Reader) (*Order, error) {
+	var o Order
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", OrderFileName, err)
+	}
+	if o.FormatVersion > formatVersion {
+		return nil, fmt.Errorf("%s has unsupported format version %d", OrderFileName, o.FormatVersion)
+	}
+	return &o, nil
+}
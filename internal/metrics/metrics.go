@@ -0,0 +1,187 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package metrics writes metrics in the Prometheus text exposition format,
+// for the node_exporter textfile collector and the daemon's /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Metric types
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Family is a metric with all of its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is one value of a metric. Labels holds name and value pairs.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Add appends a sample with the given label pairs.
+func (f *Family) Add(value float64, labels ...string) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// Merge combines the families of several jobs, so that all samples of a
+// metric are written together as the format requires. The order of the first
+// appearance of each metric is kept.
+func Merge(sets ...[]Family) []Family {
+	var out []Family
+	index := map[string]int{}
+	for _, set := range sets {
+		for _, f := range set {
+			if i, ok := index[f.Name]; ok {
+				out[i].Samples = append(out[i].Samples, f.Samples...)
+				continue
+			}
+			index[f.Name] = len(out)
+			f.Samples = append([]Sample(nil), f.Samples...)
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Write writes the families in the text exposition format. Families without
+// samples are left out.
+func Write(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i := 0; i+1 < len(s.Labels); i += 2 {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", s.Labels[i], labelEscaper.Replace(s.Labels[i+1]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteFile replaces path with the families. The file is written under a
+// temporary name in the same directory and renamed, so the textfile collector
+// never reads a partial file; it ignores files not ending in .prom.
+func WriteFile(path string, families []Family) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = Write(f, families)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// ReadValue returns the value of the first sample of the metric name in a
+// file written by WriteFile. ok is false when the file or the metric does
+// not exist.
+func ReadValue(path, name string) (value float64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		rest, found := strings.CutPrefix(line, name)
+		if !found || (rest == "" || (rest[0] != ' ' && rest[0] != '{')) {
+			continue
+		}
+		fields := strings.Fields(line[strings.LastIndexByte(line, '}')+1:])
+		if rest[0] == ' ' {
+			fields = strings.Fields(rest)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,168 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mysqlutil
+
+import (
+	"bufio"
+	"database/sql"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// defaultSockets are tried, like the mysql client does, when connecting to
+// localhost without a port or socket.
+var defaultSockets = []string{
+	"/var/run/mysqld/mysqld.sock",
+	"/run/mysqld/mysqld.sock",
+	"/var/lib/mysql/mysql.sock",
+	"/tmp/mysql.sock",
+}
+
+// Open returns a database/sql handle for the server described by the
+// connection options. Settings missing from the options are read from the
+// [client] and [mysqldump] groups of the defaults file, as mysqldump does.
+func Open(opts config.ConnectionOptions) (*sql.DB, error) {
+	cfg, err := DriverConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// DriverConfig builds the go-sql-driver configuration for the connection
+// options.
+func DriverConfig(opts config.ConnectionOptions) (*mysql.Config, error) {
+	values := map[string]string{}
+	if opts.DefaultsFile != "" {
+		path := config.ExpandTilde(opts.DefaultsFile)
+		if _, err := os.Stat(path); err == nil {
+			groups := []string{"client", "mysqldump"}
+			if opts.DefaultsGroupSuffix != "" {
+				groups = append(groups, "client"+opts.DefaultsGroupSuffix, "mysqldump"+opts.DefaultsGroupSuffix)
+			}
+			if values, err = readDefaultsFile(path, groups); err != nil {
+				return nil, err
+			}
+		}
+	}
+	set := func(key, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+	set("user", opts.User)
+	set("password", opts.Password)
+	set("host", opts.Host)
+	set("port", opts.Port)
+	set("socket", opts.Socket)
+
+	cfg := mysql.NewConfig()
+	cfg.User = values["user"]
+	if cfg.User == "" {
+		if u, err := user.Current(); err == nil {
+			cfg.User = u.Username
+		}
+	}
+	cfg.Passwd = values["password"]
+	cfg.TLSConfig = "preferred"
+	cfg.Timeout = 30 * time.Second
+	host, port, socket := values["host"], values["port"], values["socket"]
+	if socket == "" && port == "" && (host == "" || host == "localhost") {
+		for _, s := range defaultSockets {
+			if _, err := os.Stat(s); err == nil {
+				socket = s
+				break
+			}
+		}
+	}
+	switch {
+	case socket != "" && (host == "" || host == "localhost"):
+		cfg.Net, cfg.Addr = "unix", socket
+	default:
+		if host == "" || host == "localhost" {
+			host = "127.0.0.1"
+		}
+		if port == "" {
+			port = "3306"
+		}
+		cfg.Net, cfg.Addr = "tcp", net.JoinHostPort(host, port)
+	}
+	return cfg, nil
+}
+
+// readDefaultsFile returns the options of the given groups of a MySQL option
+// file; later groups override earlier ones. !include directives are ignored.
+func readDefaultsFile(path string, groups []string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]string{}
+	// Options are applied group by group so that the order of groups wins
+	// over the order in the file
+	byGroup := map[string]map[string]string{}
+	group := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!':
+			continue
+		case line[0] == '[' && strings.HasSuffix(line, "]"):
+			group = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if byGroup[group] == nil {
+			byGroup[group] = map[string]string{}
+		}
+		byGroup[group][key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		for k, v := range byGroup[g] {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
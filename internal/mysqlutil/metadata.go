@@ -0,0 +1,182 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mysqlutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// Metadata looks up the databases and tables of the server and their sizes.
+// Names are always passed as query parameters or quoted identifiers, never
+// pasted into SQL.
+type Metadata interface {
+	// DatabasesLike returns the databases matching a LIKE pattern.
+	DatabasesLike(pattern string) ([]string, error)
+	// TablesLike returns the tables and views of db matching a LIKE pattern.
+	TablesLike(db, pattern string) ([]string, error)
+	// ListTables returns the base tables and the views of db.
+	ListTables(db string) (tables, views []string, err error)
+	// DatabaseSize returns the size of the data and indexes of the databases.
+	DatabaseSize(databases []string) (int, error)
+	// TableSize returns the size of the data and indexes of a table.
+	TableSize(db, table string) (int, error)
+	// ServerVersion returns the version reported by the server, e.g. 8.0.36.
+	ServerVersion() (string, error)
+	// Close closes the connections to the server.
+	Close() error
+}
+
+// queryTimeout bounds every metadata query.
+const queryTimeout = 60 * time.Second
+
+// sqlMetadata answers metadata queries over a database/sql connection pool.
+type sqlMetadata struct {
+	db *sql.DB
+}
+
+// NewMetadata returns a Metadata for the server described by the connection
+// options. The server is only contacted by the first query.
+func NewMetadata(opts config.ConnectionOptions) (Metadata, error) {
+	db, err := Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlMetadata{db: db}, nil
+}
+
+func (m *sqlMetadata) DatabasesLike(pattern string) ([]string, error) {
+	return m.column("SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME LIKE ? ORDER BY SCHEMA_NAME", pattern)
+}
+
+func (m *sqlMetadata) TablesLike(db, pattern string) ([]string, error) {
+	return m.column("SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME LIKE ? ORDER BY TABLE_NAME", db, pattern)
+}
+
+func (m *sqlMetadata) ListTables(db string) (tables, views []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	rows, err := m.db.QueryContext(ctx, "SHOW FULL TABLES FROM "+QuoteIdent(db))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list tables of %s: %w", db, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, nil, err
+		}
+		if kind == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to list tables of %s: %w", db, err)
+	}
+	return tables, views, nil
+}
+
+func (m *sqlMetadata) DatabaseSize(databases []string) (int, error) {
+	if len(databases) == 0 {
+		return 0, fmt.Errorf("no databases to size")
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(databases)), ", ")
+	args := make([]any, len(databases))
+	for i, db := range databases {
+		args[i] = db
+	}
+	size, err := m.size("SELECT SUM(data_length + index_length) FROM information_schema.TABLES WHERE table_schema IN ("+placeholders+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	if !size.Valid {
+		return 0, fmt.Errorf("query returned no size; check if databases exist")
+	}
+	return int(size.Int64), nil
+}
+
+func (m *sqlMetadata) TableSize(db, table string) (int, error) {
+	size, err := m.size("SELECT data_length + index_length FROM information_schema.TABLES WHERE table_schema = ? AND table_name = ?", db, table)
+	if err != nil {
+		return 0, err
+	}
+	return int(size.Int64), nil
+}
+
+func (m *sqlMetadata) ServerVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	var version string
+	if err := m.db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to execute query: %w", err)
+	}
+	return version, nil
+}
+
+func (m *sqlMetadata) Close() error {
+	return m.db.Close()
+}
+
+// column runs a query and returns the first column of every row.
+func (m *sqlMetadata) column(query string, args ...any) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	return out, nil
+}
+
+// size runs a query returning a single, possibly NULL, number of bytes. A
+// query matching no row returns a NULL size.
+func (m *sqlMetadata) size(query string, args ...any) (sql.NullInt64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	var size sql.NullInt64
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(&size)
+	if err != nil && err != sql.ErrNoRows {
+		return size, fmt.Errorf("failed to execute query: %w", err)
+	}
+	return size, nil
+}
@@ -24,23 +24,62 @@
 package mysqlutil
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/trustservers-hosting/mymagicdump/internal/config"
 	"github.com/trustservers-hosting/mymagicdump/internal/logging"
 )
 
-// buildConnectionFlags creates the flags for connecting to the MySQL instance.
-func BuildConnectionFlags(opts config.Options) []string {
-	args := []string{}
-	if opts.User != "" {
-		args = append(args, "-u", opts.User)
+// BuildConnectionFlags creates the flags for connecting the mysql and
+// mysqldump clients to the MySQL instance. The password is never put on the
+// command line, where every user of the host can read it with ps: it is
+// written to a temporary option file only the current user can read. remove
+// deletes that file and must be called once the clients have finished.
+//
+// An explicit --defaults-file replaces the option files the clients read, so
+// it is passed on, included by the temporary file when there is a password.
+// Otherwise the temporary file is passed as --defaults-extra-file and the
+// clients still read /etc/my.cnf, ~/.my.cnf and the other standard files.
+func BuildConnectionFlags(opts config.ConnectionOptions) (args []string, remove func(), err error) {
+	remove = func() {}
+	defaultsFile := ""
+	if opts.DefaultsFile != "" && opts.DefaultsFile != config.DefaultDefaultsFile {
+		expanded := config.ExpandTilde(opts.DefaultsFile)
+		if _, err := os.Stat(expanded); err == nil {
+			// The credentials file lives elsewhere and includes it
+			if abs, err := filepath.Abs(expanded); err == nil {
+				expanded = abs
+			}
+			defaultsFile = expanded
+		} else {
+			logging.Info("Skipping --defaults-file: %s not found", expanded)
+		}
 	}
+	// The clients only accept the option file options before all others
+	option := "--defaults-file="
 	if opts.Password != "" {
-		args = append(args, "-p"+opts.Password)
+		if defaultsFile == "" {
+			option = "--defaults-extra-file="
+		}
+		if defaultsFile, err = writeCredentialsFile(defaultsFile, opts.Password); err != nil {
+			return nil, remove, fmt.Errorf("write credentials file: %w", err)
+		}
+		path := defaultsFile
+		remove = func() { os.Remove(path) }
+	}
+	if defaultsFile != "" {
+		args = append(args, option+defaultsFile)
+	}
+	if opts.DefaultsGroupSuffix != "" {
+		args = append(args, "--defaults-group-suffix="+opts.DefaultsGroupSuffix)
+	}
+	if opts.User != "" {
+		args = append(args, "-u", opts.User)
 	}
 	if opts.Host != "" {
 		args = append(args, "-h", opts.Host)
@@ -51,46 +90,51 @@ func BuildConnectionFlags(opts config.Options) []string {
 	if opts.Socket != "" {
 		args = append(args, "--socket", opts.Socket)
 	}
-	if opts.DefaultsFile != "" {
-		expanded := config.ExpandTilde(opts.DefaultsFile)
-		if _, err := os.Stat(expanded); err == nil {
-			args = append(args, "--defaults-file="+expanded)
-			if opts.DefaultsGroupSuffix != "" {
-				args = append(args, "--defaults-group-suffix="+opts.DefaultsGroupSuffix)
-			}
-		} else {
-			logging.Info("Skipping --defaults-file: %s not found", expanded)
-		}
-	}
-	return args
+	return args, remove, nil
 }
 
-func GetTablesMatchingGlob(mysqlConnFlags []string, dbName, globPattern string) ([]string, error) {
-	likePattern := globToLike(globPattern)
-	cmd := exec.Command("mysql", append(mysqlConnFlags, "-sNe", fmt.Sprintf("SHOW TABLES IN %s LIKE '%s';", dbName, likePattern))...)
-	output, err := cmd.CombinedOutput()
+// writeCredentialsFile writes an option file with the password in its
+// [client] group. The options of include are read first, so the password
+// overrides one set there. os.CreateTemp creates the file with mode 0600.
+func writeCredentialsFile(include, password string) (string, error) {
+	f, err := os.CreateTemp("", "mymagicdump-*.cnf")
 	if err != nil {
-		return nil, fmt.Errorf("command failed: %v, output: %s", err, string(output))
+		return "", err
 	}
-	lines := strings.Split(string(output), "\n")
-	var tables []string
-	for _, line := range lines {
-		if t := strings.TrimSpace(line); t != "" {
-			tables = append(tables, t)
-		}
+	var b strings.Builder
+	if include != "" {
+		fmt.Fprintf(&b, "!include %s\n\n", include)
+	}
+	fmt.Fprintf(&b, "[client]\npassword=\"%s\"\n", optionEscaper.Replace(password))
+	_, err = f.WriteString(b.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
 	}
-	return tables, nil
+	return f.Name(), nil
+}
+
+// optionEscaper escapes a value for a double-quoted option file value.
+var optionEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// GetTablesMatchingGlob returns the tables and views of a database matching
+// a shell-like pattern.
+func GetTablesMatchingGlob(meta Metadata, dbName, globPattern string) ([]string, error) {
+	return meta.TablesLike(dbName, globToLike(globPattern))
 }
 
 // ExpandDatabaseList expands shell-like patterns in the provided database list.
 // Supports '*' and '?' wildcards. If an entry contains wildcards, it is resolved
-// against MySQL via SHOW DATABASES LIKE ... Otherwise the name is used as-is.
-func ExpandDatabaseList(mysqlConnFlags []string, entries []string) ([]string, error) {
+// against the databases of the server. Otherwise the name is used as-is.
+func ExpandDatabaseList(meta Metadata, entries []string) ([]string, error) {
 	var out []string
 	for _, e := range entries {
 		if hasGlobWildcards(e) {
 			likePattern := globToLike(e)
-			matched, err := resolveDBLike(mysqlConnFlags, likePattern)
+			matched, err := meta.DatabasesLike(likePattern)
 			if err != nil {
 				// Log and continue with next entry
 				logging.Warn("Failed resolving databases for pattern %q: %v", e, err)
@@ -131,33 +175,12 @@ func globToLike(glob string) string {
 	return esc
 }
 
-func resolveDBLike(mysqlConnFlags []string, likePattern string) ([]string, error) {
-	query := fmt.Sprintf("SHOW DATABASES LIKE '%s';", likePattern)
-	cmd := exec.Command("mysql", append(mysqlConnFlags, "-sNe", query)...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("command failed: %v, output: %s", err, string(output))
-	}
-	lines := strings.Split(string(output), "\n")
-	var dbs []string
-	for _, line := range lines {
-		if t := strings.TrimSpace(line); t != "" {
-			dbs = append(dbs, t)
-		}
-	}
-	return dbs, nil
-}
-
-func ExtractDatabasesFromFlags(mysqlDumpFlags []string) []string {
+// ExtractDatabasesFromFlags returns the databases a mysqldump run with the
+// given flags dumps.
+func ExtractDatabasesFromFlags(meta Metadata, mysqlDumpFlags []string) ([]string, error) {
 	for _, flag := range mysqlDumpFlags {
 		if flag == "--all-databases" {
-			cmd := exec.Command("mysql", "-sNe", "SHOW DATABASES;")
-			output, _ := cmd.CombinedOutput()
-			all := strings.Split(string(output), "\n")
-			if len(all) > 0 {
-				all = all[:len(all)-1]
-			}
-			return all
+			return meta.DatabasesLike("%")
 		}
 	}
 	for i, flag := range mysqlDumpFlags {
@@ -168,32 +191,82 @@ func ExtractDatabasesFromFlags(mysqlDumpFlags []string) []string {
 					databases = append(databases, flag2)
 				}
 			}
-			return databases
+			return databases, nil
 		}
 	}
 	if len(mysqlDumpFlags) > 0 {
-		return []string{mysqlDumpFlags[len(mysqlDumpFlags)-1]}
+		return []string{mysqlDumpFlags[len(mysqlDumpFlags)-1]}, nil
+	}
+	return nil, nil
+}
+
+// RedactArgs returns a copy of mysql/mysqldump arguments with passwords and
+// registered secrets replaced, for logs and reports.
+func RedactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--password="):
+			out[i] = "--password=***"
+		case strings.HasPrefix(arg, "-p") && len(arg) > 2:
+			out[i] = "-p***"
+		default:
+			out[i] = logging.Redact(arg)
+		}
 	}
-	return nil
+	return out
+}
+
+// ReadLock is a global read lock held by a server session. While it is held
+// the server accepts no writes, so every dump taken in the meantime sees the
+// same data.
+type ReadLock struct {
+	db   *sql.DB
+	conn *sql.Conn
 }
 
-func CalculateDatabaseSize(mysqlConnFlags []string, targetDatabases []string) (int, error) {
-	query := `
-        SELECT ROUND(SUM(data_length + index_length), 0)
-        FROM information_schema.TABLES
-        WHERE table_schema IN ('` + strings.Join(targetDatabases, "', '") + `')
-    `
-	cmd := exec.Command("mysql", append(mysqlConnFlags, []string{"-sNe", query}...)...)
-	output, err := cmd.Output()
+// lockWaitTimeout is how long, in seconds, to wait for running statements
+// before giving up on the global read lock.
+const lockWaitTimeout = 60
+
+// LockForSnapshot runs FLUSH TABLES WITH READ LOCK in a new session and
+// returns once the lock is held.
+func LockForSnapshot(opts config.ConnectionOptions) (*ReadLock, error) {
+	db, err := Open(opts)
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute query: %w", err)
+		return nil, err
 	}
-	if string(output) == "" {
-		return 0, fmt.Errorf("query returned empty output; check if databases exist")
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("SET SESSION lock_wait_timeout = %d", lockWaitTimeout))
+		if err == nil {
+			_, err = conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK")
+		}
+		if err != nil {
+			conn.Close()
+		}
 	}
-	var sz int
-	if _, err := fmt.Sscanf(strings.TrimSpace(string(output)), "%d", &sz); err != nil {
-		return 0, fmt.Errorf("failed to convert output to int: %w", err)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("global read lock failed: %w", err)
 	}
-	return sz, nil
+	return &ReadLock{db: db, conn: conn}, nil
+}
+
+// Release unlocks the tables and ends the session.
+func (l *ReadLock) Release() error {
+	_, err := l.conn.ExecContext(context.Background(), "UNLOCK TABLES")
+	if cerr := l.conn.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := l.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// QuoteIdent quotes a database, table or column name for use in a query.
+func QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
 }
@@ -0,0 +1,128 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mysqlutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// ResolvePassword sets opts.Password from the password source selected by
+// the options: --password-file, --password-env, --password-command, or a
+// prompt on the terminal for -p without a value. At most one source, -p
+// included, may be given.
+func ResolvePassword(opts *config.ConnectionOptions) error {
+	sources := 0
+	for _, v := range []string{opts.Password, opts.PasswordFile, opts.PasswordEnv, opts.PasswordCommand} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of --password, --password-file, --password-env and --password-command can be given")
+	}
+	var err error
+	switch {
+	case opts.Password == config.PromptPassword:
+		opts.Password, err = promptPassword()
+	case opts.PasswordFile != "":
+		opts.Password, err = readPasswordFile(config.ExpandTilde(opts.PasswordFile))
+	case opts.PasswordEnv != "":
+		value, ok := os.LookupEnv(opts.PasswordEnv)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", opts.PasswordEnv)
+		}
+		opts.Password = value
+	case opts.PasswordCommand != "":
+		opts.Password, err = runPasswordCommand(opts.PasswordCommand)
+	}
+	return err
+}
+
+// promptPassword asks for the password on the terminal without echoing it.
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("cannot ask for the password: standard input is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, "Enter password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return string(password), nil
+}
+
+// readPasswordFile returns the first line of a password file.
+func readPasswordFile(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		logging.Warn("Password file %s can be read by other users (mode %04o)", path, fi.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return line, nil
+}
+
+// runPasswordCommand runs command with the shell and returns the first line
+// of its output. The command can use the terminal, e.g. to ask for the
+// passphrase of a password store.
+func runPasswordCommand(command string) (string, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command failed: %w", err)
+	}
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" {
+		return "", errors.New("password command printed no password")
+	}
+	return line, nil
+}
@@ -0,0 +1,171 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// Email sends plain text messages through an SMTP server.
+type Email struct {
+	host     string
+	port     int
+	tlsMode  string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+// NewEmail returns an Email sender for the --notify and --smtp-* options.
+func NewEmail(opts *config.Options) (*Email, error) {
+	e := &Email{
+		host:     opts.SMTPHost,
+		port:     opts.SMTPPort,
+		tlsMode:  opts.SMTPTLS,
+		user:     opts.SMTPUser,
+		password: opts.SMTPPassword,
+		from:     opts.SMTPFrom,
+	}
+	if e.port == 0 {
+		e.port = 25
+		if e.tlsMode == "tls" {
+			e.port = 465
+		}
+	}
+	if e.from == "" {
+		hostname, _ := os.Hostname()
+		e.from = "mymagicdump@" + hostname
+	}
+	if _, err := mail.ParseAddress(e.from); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", e.from, err)
+	}
+	for _, addr := range opts.NotifyEmail {
+		addr = strings.TrimSpace(addr)
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid notification address %q: %w", addr, err)
+		}
+		e.to = append(e.to, addr)
+	}
+	if len(e.to) == 0 {
+		return nil, fmt.Errorf("no notification address given")
+	}
+	return e, nil
+}
+
+// String returns the recipients for log messages.
+func (e *Email) String() string {
+	return strings.Join(e.to, ", ")
+}
+
+// Send delivers a message with the given subject and body to all recipients.
+func (e *Email) Send(subject, body string) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	tlsConfig := &tls.Config{ServerName: e.host}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if e.tlsMode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// Do not hang forever on an unresponsive server
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if hostname, err := os.Hostname(); err == nil {
+		if err := c.Hello(hostname); err != nil {
+			return err
+		}
+	}
+	if e.tlsMode == "auto" || e.tlsMode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("starttls: %w", err)
+			}
+		} else if e.tlsMode == "starttls" {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+	}
+	if e.user != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection to anything but localhost
+		if err := c.Auth(smtp.PlainAuth("", e.user, e.password, e.host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, rcpt := range e.to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the headers and body of the mail. Line endings are
+// converted to CRLF by the SMTP client.
+func (e *Email) message(subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\n", e.from)
+	fmt.Fprintf(&b, "To: %s\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
@@ -0,0 +1,150 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, prefixed
+// with "sha256=", when a webhook secret is set.
+const SignatureHeader = "X-Mymagicdump-Signature"
+
+// Webhook POSTs run reports to an HTTP endpoint.
+type Webhook struct {
+	url    string
+	secret []byte
+	format string
+	tmpl   *template.Template
+	client *http.Client
+}
+
+// NewWebhook returns a webhook for url using the --webhook-* options. The
+// payload is the JSON report, a Slack/Mattermost message, or the output of a
+// text/template file executed with the report.
+func NewWebhook(rawURL string, opts *config.Options) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	w := &Webhook{
+		url:    rawURL,
+		format: opts.WebhookTemplate,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if opts.WebhookSecret != "" {
+		w.secret = []byte(opts.WebhookSecret)
+	}
+	switch w.format {
+	case "json", "slack":
+	default:
+		data, err := os.ReadFile(config.ExpandTilde(w.format))
+		if err != nil {
+			return nil, fmt.Errorf("webhook template: %w", err)
+		}
+		w.tmpl, err = template.New(w.format).Funcs(template.FuncMap{"json": toJSON}).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("webhook template: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// String returns the webhook URL without credentials for log messages.
+func (w *Webhook) String() string {
+	if u, err := url.Parse(w.url); err == nil {
+		return u.Redacted()
+	}
+	return w.url
+}
+
+// Send posts report. subject and text are the human readable summary used
+// for Slack style messages.
+func (w *Webhook) Send(ctx context.Context, report any, subject, text string) error {
+	body, err := w.payload(report, subject, text)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mymagicdump")
+	if w.secret != nil {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (w *Webhook) payload(report any, subject, text string) ([]byte, error) {
+	switch w.format {
+	case "json":
+		return json.Marshal(report)
+	case "slack":
+		// Understood by Slack, Mattermost and most compatible incoming webhooks
+		return json.Marshal(map[string]string{"text": subject + "\n```\n" + text + "```"})
+	}
+	var b bytes.Buffer
+	data := struct {
+		Report  any
+		Subject string
+		Text    string
+	}{report, subject, text}
+	if err := w.tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("webhook template: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// toJSON is available in templates to quote values, e.g. {{json .Text}}.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,354 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/compress"
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/encrypt"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
+	"github.com/trustservers-hosting/mymagicdump/internal/mysqlutil"
+)
+
+const (
+	schemaSuffix = "_schema.sql"
+	dataSuffix   = "_data.sql"
+	// multiDumpName is the dump of several databases written without
+	// --separate-dumps.
+	multiDumpName = "multiple_databases.sql"
+)
+
+type Restorer struct {
+	Opts       *config.RestoreOptions
+	ConnFlags  []string
+	Identities []age.Identity
+}
+
+func NewRestorer(opts *config.RestoreOptions) *Restorer {
+	return &Restorer{Opts: opts}
+}
+
+// Run restores every file given on the command line, in order. A directory
+// holding a per-table dump is restored in the order of its RESTORE_ORDER.json.
+func (r *Restorer) Run() error {
+	if err := mysqlutil.ResolvePassword(&r.Opts.ConnectionOptions); err != nil {
+		logging.Error("Cannot read the MySQL password: %v", err)
+		return err
+	}
+	logging.AddSecret(r.Opts.Password)
+	connFlags, removeCredentials, err := mysqlutil.BuildConnectionFlags(r.Opts.ConnectionOptions)
+	if err != nil {
+		logging.Error("Failed to pass the password to the mysql client: %v", err)
+		return err
+	}
+	defer removeCredentials()
+	r.ConnFlags = connFlags
+	identities, err := encrypt.Identities(r.Opts.Identities, r.Opts.PassphraseFile)
+	if err != nil {
+		logging.Error("Failed to load decryption identities: %v", err)
+		return err
+	}
+	r.Identities = identities
+	if r.Opts.DryRun {
+		logging.Info("Dry-run mode enabled. No commands will be executed.")
+	}
+	for _, f := range orderFiles(r.Opts.Args.Files) {
+		restoreFn := r.restoreFile
+		if fi, err := os.Stat(f); err == nil && fi.IsDir() {
+			restoreFn = r.restoreDir
+		}
+		if err := restoreFn(f); err != nil {
+			logging.Error("Restore of %s failed: %v", f, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFile replays every .sql member of a dump file or archive. Data members
+// of a split dump (<db>_data.sql) are deferred to a second pass when they show up
+// before their matching <db>_schema.sql member. Members of a per-table dump
+// (<db>/<table>_data.sql) are replayed in the database named by their directory,
+// other members in the database memberDatabase derives from their name.
+func (r *Restorer) restoreFile(path string) error {
+	logging.Info("Restoring %s", path)
+	single := true
+	if r.Opts.Database != "" {
+		var err error
+		if single, err = singleDatabase(path, r.Identities); err != nil {
+			return err
+		}
+	}
+	replay := func(name string, size int64, rd io.Reader) error {
+		database, create := memberDatabase(name, r.Opts.Database, single)
+		return r.apply(name, database, create, size, rd)
+	}
+	seenSchema := map[string]bool{}
+	deferred := map[string]bool{}
+	err := compress.WalkArchive(path, r.Identities, func(name string, size int64, rd io.Reader) error {
+		if name == manifest.OrderFileName {
+			return nil
+		}
+		if !strings.HasSuffix(name, ".sql") {
+			logging.Warn("Skipping %s: not an .sql file", name)
+			return nil
+		}
+		if base, ok := strings.CutSuffix(name, schemaSuffix); ok {
+			seenSchema[base] = true
+		}
+		if base, ok := strings.CutSuffix(name, dataSuffix); ok && !seenSchema[base] {
+			logging.Debug("Deferring %s until its schema has been restored", name)
+			deferred[name] = true
+			return nil
+		}
+		return replay(name, size, rd)
+	})
+	if err != nil || len(deferred) == 0 {
+		return err
+	}
+	return compress.WalkArchive(path, r.Identities, func(name string, size int64, rd io.Reader) error {
+		if !deferred[name] {
+			return nil
+		}
+		return replay(name, size, rd)
+	})
+}
+
+// singleDatabase reports whether the top-level .sql members of path all belong
+// to one database. Members of an archive are only listed, not read, but a tar
+// archive is still decompressed to find them.
+func singleDatabase(file string, identities []age.Identity) (bool, error) {
+	databases := map[string]bool{}
+	err := compress.WalkArchive(file, identities, func(name string, _ int64, _ io.Reader) error {
+		if path.Dir(name) != "." || !strings.HasSuffix(name, ".sql") {
+			return nil
+		}
+		databases[dumpDatabase(name)] = true
+		return nil
+	})
+	return len(databases) <= 1, err
+}
+
+// restoreDir replays the files of a per-table dump written without an
+// archive, following the RESTORE_ORDER.json in dir.
+func (r *Restorer) restoreDir(dir string) error {
+	orderFile, err := findDumpFile(dir, manifest.OrderFileName)
+	if err != nil {
+		return err
+	}
+	var order *manifest.Order
+	err = compress.WalkArchive(orderFile, r.Identities, func(_ string, _ int64, rd io.Reader) error {
+		order, err = manifest.ReadOrder(rd)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logging.Info("Restoring %d files from %s", len(order.Steps), dir)
+	for _, step := range order.Steps {
+		file, err := findDumpFile(dir, step.File)
+		if err != nil {
+			return err
+		}
+		// The database does not exist before its CREATE DATABASE ran
+		database := step.Database
+		if step.Kind == manifest.KindDatabase {
+			database = ""
+		}
+		err = compress.WalkArchive(file, r.Identities, func(_ string, size int64, rd io.Reader) error {
+			return r.apply(step.File, database, false, size, rd)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findDumpFile returns the file in dir holding the dump name, which may have
+// been compressed or encrypted on its own, e.g. shop/orders_data.sql.zst.age.
+func findDumpFile(dir, name string) (string, error) {
+	want := filepath.Join(dir, filepath.FromSlash(name))
+	matches, _ := filepath.Glob(globEscape(want) + "*")
+	for _, m := range matches {
+		if compress.DumpName(m) == filepath.Base(want) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s", name, dir)
+}
+
+// globEscape escapes the characters filepath.Match treats specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// memberDatabase returns the database an archive member is replayed in and
+// whether it has to be created first. A per-table dump member uses its
+// directory and the member creating that database uses none. The dumps of
+// --separate-dumps select no database themselves, so a top-level <db>.sql,
+// <db>_schema.sql or <db>_data.sql is replayed in <db>, or in def when it is
+// set and the archive holds a single database. multiple_databases.sql selects
+// its databases itself and is only given def.
+func memberDatabase(name, def string, single bool) (string, bool) {
+	dir := path.Dir(name)
+	switch {
+	case dir != "." && path.Base(name) == manifest.DatabaseFileName:
+		return "", false
+	case dir != ".":
+		return strings.ReplaceAll(dir, "@002f", "/"), false
+	case name == multiDumpName:
+		return def, false
+	case def != "" && single:
+		return def, true
+	}
+	return dumpDatabase(name), true
+}
+
+// dumpDatabase returns the database a dump of --separate-dumps was taken
+// from, e.g. shop_data.sql -> shop.
+func dumpDatabase(name string) string {
+	for _, suffix := range []string{schemaSuffix, dataSuffix, ".sql"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			return base
+		}
+	}
+	return name
+}
+
+// apply streams one SQL member into the mysql client, using database as the
+// default database when it is set. With create the database is created first
+// when it does not exist yet.
+func (r *Restorer) apply(name, database string, create bool, size int64, rd io.Reader) error {
+	binaryPath, err := resolveClientBinary()
+	if err != nil {
+		logging.Error("Cannot find mysql or mariadb client in PATH.")
+		return err
+	}
+	args := append([]string{}, r.ConnFlags...)
+	if r.Opts.Force {
+		args = append(args, "--force")
+	}
+	if database != "" && !create {
+		args = append(args, database)
+	}
+	cmd := exec.Command(binaryPath, args...)
+	logging.Debug("Executing command: %s", strings.Join(cmd.Args, " "))
+	if r.Opts.DryRun {
+		if create {
+			logging.Info("Would restore %s (%d bytes) into database %s", name, size, database)
+		} else {
+			logging.Info("Would restore %s (%d bytes)", name, size)
+		}
+		return nil
+	}
+
+	var bar *progressbar.ProgressBar
+	if !r.Opts.Silent {
+		bar = progressbar.DefaultBytes(size, "Restoring "+filepath.Base(name))
+		rd = io.TeeReader(rd, bar)
+	}
+	if create {
+		// The mysql client cannot connect to a database that does not exist yet
+		ident := mysqlutil.QuoteIdent(database)
+		rd = io.MultiReader(strings.NewReader("CREATE DATABASE IF NOT EXISTS "+ident+";\nUSE "+ident+";\n"), rd)
+	}
+	cmd.Stdin = rd
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	startTime := time.Now()
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			logging.Error("mysql exited with status %d while restoring %s.", exiterr.ExitCode(), name)
+		}
+		return err
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	logging.Info("Restored %s in %s", name, time.Since(startTime))
+	return nil
+}
+
+// orderFiles keeps the given order but moves every <db>_data.sql file right
+// after its <db>_schema.sql sibling when both are present. Compressed and
+// encrypted single files (e.g. <db>_data.sql.zst.age) are matched by the name
+// of the dump they contain.
+func orderFiles(files []string) []string {
+	schemas := map[string]bool{}
+	for _, f := range files {
+		if base, ok := strings.CutSuffix(compress.DumpName(f), schemaSuffix); ok {
+			schemas[filepath.Join(filepath.Dir(f), base)] = true
+		}
+	}
+	ordered := make([]string, 0, len(files))
+	for _, f := range files {
+		if base, ok := strings.CutSuffix(compress.DumpName(f), dataSuffix); ok && schemas[filepath.Join(filepath.Dir(f), base)] {
+			continue
+		}
+		ordered = append(ordered, f)
+		base, ok := strings.CutSuffix(compress.DumpName(f), schemaSuffix)
+		if !ok {
+			continue
+		}
+		for _, d := range files {
+			if filepath.Dir(d) == filepath.Dir(f) && compress.DumpName(d) == base+dataSuffix {
+				ordered = append(ordered, d)
+			}
+		}
+	}
+	return ordered
+}
+
+// resolveClientBinary returns the path to mysql or mariadb.
+func resolveClientBinary() (string, error) {
+	if path, err := exec.LookPath("mysql"); err == nil {
+		return path, nil
+	}
+	if path, err := exec.LookPath("mariadb"); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("cannot find mysql or mariadb in PATH")
+}
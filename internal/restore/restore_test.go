@@ -0,0 +1,102 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package restore
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOrderFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "no split dumps",
+			files: []string{"b.sql", "a.sql.gz"},
+			want:  []string{"b.sql", "a.sql.gz"},
+		},
+		{
+			name:  "data before schema",
+			files: []string{"shop_data.sql", "shop_schema.sql"},
+			want:  []string{"shop_schema.sql", "shop_data.sql"},
+		},
+		{
+			name:  "compressed and encrypted",
+			files: []string{"shop_data.sql.zst.age", "blog.sql", "shop_schema.sql.gz"},
+			want:  []string{"blog.sql", "shop_schema.sql.gz", "shop_data.sql.zst.age"},
+		},
+		{
+			name:  "data without schema",
+			files: []string{"shop_data.sql", "blog_schema.sql"},
+			want:  []string{"shop_data.sql", "blog_schema.sql"},
+		},
+		{
+			name:  "schema in another directory",
+			files: []string{filepath.Join("a", "shop_data.sql"), filepath.Join("b", "shop_schema.sql")},
+			want:  []string{filepath.Join("a", "shop_data.sql"), filepath.Join("b", "shop_schema.sql")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderFiles(tt.files); !slices.Equal(got, tt.want) {
+				t.Errorf("orderFiles(%q) = %q, want %q", tt.files, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemberDatabase(t *testing.T) {
+	tests := []struct {
+		name       string
+		member     string
+		def        string
+		single     bool
+		want       string
+		wantCreate bool
+	}{
+		{name: "separate dump", member: "shop.sql", single: true, want: "shop", wantCreate: true},
+		{name: "separate schema", member: "shop_schema.sql", want: "shop", wantCreate: true},
+		{name: "separate data", member: "shop_data.sql", want: "shop", wantCreate: true},
+		{name: "default for a single database", member: "shop_data.sql", def: "copy", single: true, want: "copy", wantCreate: true},
+		{name: "default ignored for several databases", member: "shop.sql", def: "copy", want: "shop", wantCreate: true},
+		{name: "several databases", member: "multiple_databases.sql", want: ""},
+		{name: "several databases with default", member: "multiple_databases.sql", def: "copy", single: true, want: "copy"},
+		{name: "per-table member", member: "shop/orders_data.sql", def: "copy", single: true, want: "shop"},
+		{name: "per-table database", member: "shop/_database.sql", want: ""},
+		{name: "escaped slash", member: "a@002fb/t_schema.sql", want: "a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, create := memberDatabase(tt.member, tt.def, tt.single)
+			if got != tt.want || create != tt.wantCreate {
+				t.Errorf("memberDatabase(%q, %q, %v) = %q, %v, want %q, %v",
+					tt.member, tt.def, tt.single, got, create, tt.want, tt.wantCreate)
+			}
+		})
+	}
+}
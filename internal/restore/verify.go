@@ -0,0 +1,181 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/compress"
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/encrypt"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+	"github.com/trustservers-hosting/mymagicdump/internal/manifest"
+)
+
+type Verifier struct {
+	Opts       *config.VerifyOptions
+	Identities []age.Identity
+	// problems counts the files that failed a check
+	problems int
+}
+
+func NewVerifier(opts *config.VerifyOptions) *Verifier {
+	return &Verifier{Opts: opts}
+}
+
+// Run checks every directory or file given on the command line and returns
+// an error if any file is missing or corrupted.
+func (v *Verifier) Run() error {
+	identities, err := encrypt.Identities(v.Opts.Identities, v.Opts.PassphraseFile)
+	if err != nil {
+		logging.Error("Failed to load decryption identities: %v", err)
+		return err
+	}
+	v.Identities = identities
+	checked := 0
+	for _, p := range v.Opts.Args.Paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			logging.Error("%v", err)
+			v.problems++
+			continue
+		}
+		if fi.IsDir() {
+			checked += v.verifyDir(p)
+		} else {
+			v.verifyFile(p)
+			checked++
+		}
+	}
+	if v.problems > 0 {
+		logging.Error("Verification failed: %d problem(s) found", v.problems)
+		return fmt.Errorf("%d problem(s) found", v.problems)
+	}
+	logging.Info("All %d file(s) OK", checked)
+	return nil
+}
+
+// verifyDir checks the files listed in the manifest of dir and returns how
+// many were checked.
+func (v *Verifier) verifyDir(dir string) int {
+	files, err := listedFiles(dir)
+	if err != nil {
+		logging.Error("%s: %v", dir, err)
+		v.problems++
+		return 0
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !v.check(path, dir, f) {
+			continue
+		}
+		if v.Opts.Deep {
+			v.readThrough(path)
+		}
+	}
+	return len(files)
+}
+
+// verifyFile checks a single file against the manifest next to it, if it is
+// listed there, and reads it through.
+func (v *Verifier) verifyFile(path string) {
+	dir := filepath.Dir(path)
+	if files, err := listedFiles(dir); err == nil {
+		for _, f := range files {
+			if filepath.Join(dir, filepath.FromSlash(f.Name)) == filepath.Clean(path) {
+				if !v.check(path, dir, f) {
+					return
+				}
+				break
+			}
+		}
+	} else {
+		logging.Debug("No manifest for %s: %v", path, err)
+	}
+	v.readThrough(path)
+}
+
+func (v *Verifier) check(path, dir string, f manifest.File) bool {
+	if err := manifest.Check(dir, f); err != nil {
+		logging.Error("%s: %v", path, err)
+		v.problems++
+		return false
+	}
+	logging.Info("%s: checksum OK", path)
+	return true
+}
+
+// readThrough decompresses (and decrypts) every member of path, which catches
+// corruption in files without a manifest through the CRCs and checksums of
+// the compression formats.
+func (v *Verifier) readThrough(path string) {
+	if strings.HasSuffix(path, encrypt.Ext) && len(v.Identities) == 0 {
+		logging.Warn("%s: encrypted; contents not checked without --identity or --passphrase-file", path)
+		return
+	}
+	var members int
+	var total int64
+	err := compress.WalkArchive(path, v.Identities, func(name string, size int64, r io.Reader) error {
+		n, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if size >= 0 && n != size {
+			return fmt.Errorf("%s: expected %d bytes, read %d", name, size, n)
+		}
+		members++
+		total += n
+		return nil
+	})
+	if err != nil {
+		logging.Error("%s: %v", path, err)
+		v.problems++
+		return
+	}
+	logging.Info("%s: contents OK (%d member(s), %d bytes)", path, members, total)
+}
+
+// listedFiles returns the files listed in the MANIFEST.json of dir, or in
+// its SHA256SUMS when there is no manifest.
+func listedFiles(dir string) ([]manifest.File, error) {
+	m, err := manifest.Read(filepath.Join(dir, manifest.FileName))
+	if err == nil {
+		return m.Files, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	files, err := manifest.ReadSums(filepath.Join(dir, manifest.SumsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no %s or %s found", manifest.FileName, manifest.SumsFileName)
+	}
+	return files, err
+}
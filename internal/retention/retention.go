@@ -0,0 +1,136 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunLayout is the name format of the timestamped run directories.
+const RunLayout = "2006-01-02_15-04-05"
+
+// Policy describes which runs to keep. A run is kept if any rule selects it:
+// the KeepLast newest runs, and the newest run of each of the last Daily days,
+// Weekly ISO weeks and Monthly months that have a run.
+type Policy struct {
+	KeepLast int
+	Daily    int
+	Weekly   int
+	Monthly  int
+	// Job limits the policy to the runs of the job of that name, so that
+	// jobs sharing a directory do not prune each other's runs
+	Job string
+}
+
+// Enabled reports whether any rule is set; without rules nothing is pruned.
+func (p Policy) Enabled() bool {
+	return p.KeepLast > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// Validate checks that no rule is negative.
+func (p Policy) Validate() error {
+	if p.KeepLast < 0 || p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 {
+		return fmt.Errorf("retention counts cannot be negative")
+	}
+	return nil
+}
+
+// RunName returns the name of the run directory of job at t: the time in
+// RunLayout, prefixed with the job name and an underscore for a named job.
+func RunName(job string, t time.Time) string {
+	if job == "" {
+		return t.Format(RunLayout)
+	}
+	return job + "_" + t.Format(RunLayout)
+}
+
+// Prune returns the names of the runs the policy does not keep. Names that
+// are not run directories of p.Job are ignored and never returned.
+func (p Policy) Prune(names []string) []string {
+	if !p.Enabled() {
+		return nil
+	}
+	type run struct {
+		name string
+		t    time.Time
+	}
+	var runs []run
+	for _, name := range names {
+		stamp := name
+		if p.Job != "" {
+			var ok bool
+			if stamp, ok = strings.CutPrefix(name, p.Job+"_"); !ok {
+				continue
+			}
+		}
+		t, err := time.ParseInLocation(RunLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run{name, t})
+	}
+	// Newest first, so each bucket keeps its most recent run
+	sort.Slice(runs, func(i, j int) bool { return runs[i].t.After(runs[j].t) })
+
+	keep := make([]bool, len(runs))
+	for i := 0; i < len(runs) && i < p.KeepLast; i++ {
+		keep[i] = true
+	}
+	buckets := []struct {
+		count int
+		key   func(time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, b := range buckets {
+		seen := make(map[string]bool)
+		for i, r := range runs {
+			if len(seen) >= b.count {
+				break
+			}
+			k := b.key(r.t)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			keep[i] = true
+		}
+	}
+
+	var prune []string
+	for i, r := range runs {
+		if !keep[i] {
+			prune = append(prune, r.name)
+		}
+	}
+	return prune
+}
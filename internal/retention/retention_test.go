@@ -0,0 +1,99 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package retention
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	run := func(job, stamp string) string {
+		ts, err := time.ParseInLocation(RunLayout, stamp, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return RunName(job, ts)
+	}
+	// Two runs a day on 2025-05-30 and 2025-05-31, one on 2025-06-01
+	names := []string{
+		run("", "2025-05-30_02-00-00"),
+		run("", "2025-05-30_14-00-00"),
+		run("", "2025-05-31_02-00-00"),
+		run("", "2025-05-31_14-00-00"),
+		run("", "2025-06-01_02-00-00"),
+		"not-a-run",
+		run("shop", "2025-05-29_02-00-00"),
+	}
+	tests := []struct {
+		name   string
+		policy Policy
+		names  []string
+		want   []string
+	}{
+		{
+			name:   "disabled",
+			policy: Policy{},
+			names:  names,
+		},
+		{
+			name:   "keep last",
+			policy: Policy{KeepLast: 2},
+			names:  names,
+			want:   []string{"2025-05-31_02-00-00", "2025-05-30_14-00-00", "2025-05-30_02-00-00"},
+		},
+		{
+			name:   "daily keeps the newest run of each day",
+			policy: Policy{Daily: 3},
+			names:  names,
+			want:   []string{"2025-05-31_02-00-00", "2025-05-30_02-00-00"},
+		},
+		{
+			name:   "monthly",
+			policy: Policy{Monthly: 1},
+			names:  names,
+			want:   []string{"2025-05-31_14-00-00", "2025-05-31_02-00-00", "2025-05-30_14-00-00", "2025-05-30_02-00-00"},
+		},
+		{
+			name:   "rules combine",
+			policy: Policy{KeepLast: 1, Monthly: 2},
+			names:  names,
+			want:   []string{"2025-05-31_02-00-00", "2025-05-30_14-00-00", "2025-05-30_02-00-00"},
+		},
+		{
+			name:   "only the runs of the job",
+			policy: Policy{KeepLast: 1, Job: "shop"},
+			names:  append(slices.Clone(names), run("shop", "2025-05-28_02-00-00")),
+			want:   []string{"shop_2025-05-28_02-00-00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Prune(tt.names); !slices.Equal(got, tt.want) {
+				t.Errorf("Prune() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
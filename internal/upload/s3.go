@@ -0,0 +1,271 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// S3 allows at most 10000 parts per multipart upload
+const maxParts = 10000
+
+// s3Destination uploads to an S3 compatible bucket. Files larger than one part
+// are sent as multipart uploads which are resumed when a previous attempt (or
+// run) left an incomplete upload for the same key behind.
+type s3Destination struct {
+	core         *minio.Core
+	bucket       string
+	prefix       string
+	partSize     int64
+	storageClass string
+	url          string
+}
+
+func newS3(u *url.URL, opts *config.Options) (*s3Destination, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing bucket in %s", u)
+	}
+	lookup := minio.BucketLookupAuto
+	if opts.S3PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	// Credentials from the usual AWS and MinIO environment variables,
+	// ~/.aws/credentials or the EC2/ECS instance role
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	core, err := minio.NewCore(opts.S3Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !opts.S3DisableTLS,
+		Region:       opts.S3Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.S3PartSize < 5 {
+		return nil, fmt.Errorf("--s3-part-size must be at least 5 MiB")
+	}
+	return &s3Destination{
+		core:         core,
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		partSize:     int64(opts.S3PartSize) << 20,
+		storageClass: opts.S3StorageClass,
+		url:          u.String(),
+	}, nil
+}
+
+func (d *s3Destination) String() string {
+	return d.url
+}
+
+func (d *s3Destination) key(name string) string {
+	return path.Join(d.prefix, name)
+}
+
+func (d *s3Destination) Upload(ctx context.Context, localPath, name string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	key := d.key(name)
+	putOpts := minio.PutObjectOptions{StorageClass: d.storageClass}
+	if fi.Size() <= d.partSize {
+		sum, err := md5Section(f, 0, fi.Size())
+		if err != nil {
+			return err
+		}
+		_, err = d.core.PutObject(ctx, d.bucket, key, io.NewSectionReader(f, 0, fi.Size()), fi.Size(),
+			base64.StdEncoding.EncodeToString(sum), "", putOpts)
+		if err != nil {
+			return err
+		}
+	} else if err := d.multipartUpload(ctx, f, fi.Size(), key, putOpts); err != nil {
+		return err
+	}
+	info, err := d.core.StatObject(ctx, d.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("verify s3://%s/%s: %w", d.bucket, key, err)
+	}
+	if info.Size != fi.Size() {
+		return fmt.Errorf("size mismatch after upload of s3://%s/%s: local %d, remote %d", d.bucket, key, fi.Size(), info.Size)
+	}
+	return nil
+}
+
+func (d *s3Destination) List(ctx context.Context) ([]string, error) {
+	prefix := ""
+	if d.prefix != "" {
+		prefix = d.prefix + "/"
+	}
+	var names []string
+	for obj := range d.core.Client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/"))
+	}
+	return names, nil
+}
+
+func (d *s3Destination) Remove(ctx context.Context, name string) error {
+	key := d.key(name)
+	if err := d.core.RemoveObject(ctx, d.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+	// Everything below key when it is a directory
+	objects := d.core.Client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{Prefix: key + "/", Recursive: true})
+	for res := range d.core.RemoveObjects(ctx, d.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if res.Err != nil {
+			return fmt.Errorf("remove s3://%s/%s: %w", d.bucket, res.ObjectName, res.Err)
+		}
+	}
+	return nil
+}
+
+func (d *s3Destination) multipartUpload(ctx context.Context, f *os.File, size int64, key string, putOpts minio.PutObjectOptions) error {
+	partSize := d.partSize
+	if minSize := (size + maxParts - 1) / maxParts; partSize < minSize {
+		// Round up to whole MiB so resumed uploads compute the same parts
+		partSize = (minSize + (1 << 20) - 1) &^ ((1 << 20) - 1)
+	}
+	uploadID, existing, err := d.resumableUpload(ctx, key)
+	if err != nil {
+		return fmt.Errorf("list incomplete uploads: %w", err)
+	}
+	if uploadID == "" {
+		if uploadID, err = d.core.NewMultipartUpload(ctx, d.bucket, key, putOpts); err != nil {
+			return fmt.Errorf("start multipart upload: %w", err)
+		}
+	} else {
+		logging.Info("Resuming incomplete upload of s3://%s/%s (%d parts already uploaded)", d.bucket, key, len(existing))
+	}
+
+	var parts []minio.CompletePart
+	for num, off := 1, int64(0); off < size; num, off = num+1, off+partSize {
+		n := min(partSize, size-off)
+		sum, err := md5Section(f, off, n)
+		if err != nil {
+			return err
+		}
+		etag := hex.EncodeToString(sum)
+		if prev, ok := existing[num]; ok && prev.Size == n && strings.Trim(prev.ETag, `"`) == etag {
+			parts = append(parts, minio.CompletePart{PartNumber: num, ETag: prev.ETag})
+			continue
+		}
+		logging.Debug("Uploading part %d of s3://%s/%s (%d bytes)", num, d.bucket, key, n)
+		part, err := d.core.PutObjectPart(ctx, d.bucket, key, uploadID, num, io.NewSectionReader(f, off, n), n,
+			// Integrity is checked through Content-MD5, no need to also sign the payload
+			minio.PutObjectPartOptions{Md5Base64: base64.StdEncoding.EncodeToString(sum), DisableContentSha256: true})
+		if err != nil {
+			// The upload is kept so that the next attempt can resume it
+			return fmt.Errorf("upload part %d: %w", num, err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: num, ETag: part.ETag})
+	}
+	if _, err = d.core.CompleteMultipartUpload(ctx, d.bucket, key, uploadID, parts, putOpts); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// resumableUpload returns the most recent incomplete multipart upload of key
+// and its uploaded parts by part number, if there is one.
+func (d *s3Destination) resumableUpload(ctx context.Context, key string) (string, map[int]minio.ObjectPart, error) {
+	res, err := d.core.ListMultipartUploads(ctx, d.bucket, key, "", "", "", 1000)
+	if isNoSuchUpload(err) {
+		// Some S3 implementations answer this instead of an empty list
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var uploadID string
+	var initiated int64
+	for _, u := range res.Uploads {
+		if u.Key == key && (uploadID == "" || u.Initiated.UnixNano() > initiated) {
+			uploadID, initiated = u.UploadID, u.Initiated.UnixNano()
+		}
+	}
+	if uploadID == "" {
+		return "", nil, nil
+	}
+	parts := map[int]minio.ObjectPart{}
+	marker := 0
+	for {
+		lp, err := d.core.ListObjectParts(ctx, d.bucket, key, uploadID, marker, 1000)
+		if isNoSuchUpload(err) {
+			// Aborted or completed in the meantime
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		for _, p := range lp.ObjectParts {
+			parts[p.PartNumber] = p
+		}
+		if !lp.IsTruncated {
+			return uploadID, parts, nil
+		}
+		marker = lp.NextPartNumberMarker
+	}
+}
+
+func isNoSuchUpload(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchUpload"
+}
+
+// md5Section returns the MD5 sum of n bytes of f starting at off.
+func md5Section(f *os.File, off, n int64) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, off, n)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
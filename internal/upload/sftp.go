@@ -0,0 +1,324 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// Default keys tried when no --sftp-identity is given, as ssh(1) does
+var defaultIdentities = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// sftpDestination uploads to a directory on an SSH server. Files are written
+// under a temporary name, verified (size and SHA-256) and only then renamed
+// to their final name.
+type sftpDestination struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+	// agentSock is the ssh-agent socket, dialed for every connection
+	agentSock string
+	url       string
+}
+
+func newSFTP(u *url.URL, opts *config.Options) (*sftpDestination, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in %s", u)
+	}
+	username := u.User.Username()
+	if username == "" {
+		cur, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("no user in %s: %w", u, err)
+		}
+		username = cur.Username
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	hostKeys, err := knownhosts.New(config.ExpandTilde(opts.SFTPKnownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("load known hosts: %w", err)
+	}
+	d := &sftpDestination{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		dir:  u.Path,
+		url:  u.Redacted(),
+	}
+	if d.dir == "" {
+		d.dir = "."
+	}
+	auth, err := d.authMethods(opts.SFTPIdentities)
+	if err != nil {
+		return nil, err
+	}
+	d.config = &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	}
+	return d, nil
+}
+
+// authMethods returns public key authentication with the given or default
+// unencrypted key files. The ssh-agent, if SSH_AUTH_SOCK is set, is added by
+// connect.
+func (d *sftpDestination) authMethods(identities []string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	d.agentSock = os.Getenv("SSH_AUTH_SOCK")
+	explicit := len(identities) > 0
+	if !explicit {
+		identities = defaultIdentities
+	}
+	var signers []ssh.Signer
+	for _, id := range identities {
+		data, err := os.ReadFile(config.ExpandTilde(id))
+		if err != nil {
+			if explicit {
+				return nil, err
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			if explicit {
+				return nil, fmt.Errorf("identity %s: %w (use ssh-agent for passphrase protected keys)", id, err)
+			}
+			logging.Debug("Skipping identity %s: %v", id, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 && d.agentSock == "" {
+		return nil, fmt.Errorf("no SSH key or agent available for %s", d.addr)
+	}
+	return methods, nil
+}
+
+func (d *sftpDestination) String() string {
+	return d.url
+}
+
+func (d *sftpDestination) Upload(ctx context.Context, localPath, name string) error {
+	conn, client, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	target := path.Join(d.dir, name)
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("create %s: %w", path.Dir(target), err)
+	}
+	tmp := target + ".part"
+	localSum, size, err := d.copyFile(client, localPath, tmp)
+	if err != nil {
+		client.Remove(tmp)
+		return err
+	}
+	if err := d.verify(conn, client, tmp, size, localSum); err != nil {
+		client.Remove(tmp)
+		return err
+	}
+	// posix-rename replaces an existing file atomically; fall back for
+	// servers without the OpenSSH extension
+	if err := client.PosixRename(tmp, target); err != nil {
+		client.Remove(target)
+		if err := client.Rename(tmp, target); err != nil {
+			client.Remove(tmp)
+			return fmt.Errorf("rename %s: %w", tmp, err)
+		}
+	}
+	return nil
+}
+
+func (d *sftpDestination) List(ctx context.Context) ([]string, error) {
+	conn, client, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer client.Close()
+	entries, err := client.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+func (d *sftpDestination) Remove(ctx context.Context, name string) error {
+	conn, client, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+	return client.RemoveAll(path.Join(d.dir, name))
+}
+
+// connect opens an SSH connection and an SFTP session on it; both must be
+// closed by the caller.
+func (d *sftpDestination) connect(ctx context.Context) (*ssh.Client, *sftp.Client, error) {
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := d.config
+	if d.agentSock != "" {
+		if agentConn, err := net.Dial("unix", d.agentSock); err == nil {
+			// The agent is only needed to authenticate
+			defer agentConn.Close()
+			withAgent := *d.config
+			withAgent.Auth = append([]ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers)}, d.config.Auth...)
+			config = &withAgent
+		} else {
+			logging.Warn("Cannot connect to ssh-agent: %v", err)
+		}
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, d.addr, config)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	conn := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, client, nil
+}
+
+// copyFile uploads localPath to remotePath and returns the SHA-256 and size
+// of the data sent.
+func (d *sftpDestination) copyFile(client *sftp.Client, localPath, remotePath string) ([]byte, int64, error) {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer in.Close()
+	out, err := client.Create(remotePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create %s: %w", remotePath, err)
+	}
+	h := sha256.New()
+	n, err := out.ReadFrom(io.TeeReader(in, h))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("write %s: %w", remotePath, err)
+	}
+	return h.Sum(nil), n, nil
+}
+
+// verify checks the size and SHA-256 of the uploaded file. The checksum is
+// computed remotely with sha256sum when the server allows running commands,
+// otherwise the file is read back.
+func (d *sftpDestination) verify(conn *ssh.Client, client *sftp.Client, remotePath string, size int64, sum []byte) error {
+	fi, err := client.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return fmt.Errorf("size mismatch after upload of %s: local %d, remote %d", remotePath, size, fi.Size())
+	}
+	remoteSum, err := remoteSHA256(conn, remotePath)
+	if err != nil {
+		logging.Debug("Remote sha256sum unavailable (%v); reading %s back", err, remotePath)
+		if remoteSum, err = readBackSHA256(client, remotePath); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(remoteSum, sum) {
+		return fmt.Errorf("checksum mismatch after upload of %s", remotePath)
+	}
+	return nil
+}
+
+func remoteSHA256(conn *ssh.Client, remotePath string) ([]byte, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	out, err := session.Output("sha256sum " + shellQuote(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+	return hex.DecodeString(fields[0])
+}
+
+func readBackSHA256(client *sftp.Client, remotePath string) ([]byte, error) {
+	f, err := client.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := f.WriteTo(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,111 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package upload
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// Destination is a remote location the finished backup files are copied to.
+type Destination interface {
+	// Upload copies the local file to name, a slash separated path relative
+	// to the root of the destination.
+	Upload(ctx context.Context, localPath, name string) error
+	// List returns the names of the entries (files and directories) in the
+	// root of the destination.
+	List(ctx context.Context) ([]string, error)
+	// Remove deletes the entry name in the root of the destination and
+	// everything below it.
+	Remove(ctx context.Context, name string) error
+	// String returns the destination URL for log messages.
+	String() string
+}
+
+// Parse returns the destination for an --upload URL.
+func Parse(rawURL string, opts *config.Options) (Destination, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload destination %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3(u, opts)
+	case "sftp":
+		return newSFTP(u, opts)
+	}
+	return nil, fmt.Errorf("unsupported upload destination %q", rawURL)
+}
+
+// UploadFiles uploads every file to dir in dest (the root when dir is empty),
+// keeping its path relative to localDir, and retries failed uploads with the
+// same --retries and --retry-interval as dumps. It returns the files that
+// were uploaded and the last error.
+func UploadFiles(ctx context.Context, dest Destination, localDir, dir string, files []string, retries int, retryInterval time.Duration) ([]string, error) {
+	var uploaded []string
+	var lastErr error
+	for _, f := range files {
+		rel, err := filepath.Rel(localDir, f)
+		if err != nil || !filepath.IsLocal(rel) {
+			rel = filepath.Base(f)
+		}
+		name := path.Join(dir, filepath.ToSlash(rel))
+		if err := uploadWithRetries(ctx, dest, f, name, retries, retryInterval); err != nil {
+			logging.Error("Upload of %s to %s failed after all retries: %v", f, dest, err)
+			lastErr = err
+			continue
+		}
+		uploaded = append(uploaded, f)
+	}
+	return uploaded, lastErr
+}
+
+func uploadWithRetries(ctx context.Context, dest Destination, localPath, name string, retries int, retryInterval time.Duration) error {
+	attempts := retries + 1
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		logging.Info("Attempt %d/%d for uploading %s to %s", i+1, attempts, name, dest)
+		startTime := time.Now()
+		if lastErr = dest.Upload(ctx, localPath, name); lastErr == nil {
+			logging.Info("Upload of %s completed successfully in %s", name, time.Since(startTime))
+			return nil
+		}
+		logging.Warn("Upload of %s failed: %v", name, lastErr)
+		if i < attempts-1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,390 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package mymagicdump runs MySQL/MariaDB backups from Go programs, with the
+// same features as the mymagicdump command, which is built on it:
+//
+//	job := &mymagicdump.Job{
+//		Name:        "shop",
+//		Connection:  mymagicdump.Connection{Host: "db1", User: "backup", PasswordFile: "/etc/mymagicdump/password"},
+//		Databases:   []string{"shop", "crm_*"},
+//		Output:      "/var/backups/mysql",
+//		Compression: mymagicdump.Compression{Type: "tzst"},
+//		Retries:     3,
+//	}
+//	res, err := job.Run(ctx)
+//
+// This package is the stable interface of mymagicdump; the packages under
+// internal may change in any release.
+package mymagicdump
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/config"
+	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+	"github.com/trustservers-hosting/mymagicdump/internal/engine"
+)
+
+// Dump engines of Job.Engine.
+const (
+	// EngineMysqldump runs mysqldump, or mariadb-dump when mysqldump is not
+	// installed
+	EngineMysqldump = engine.Mysqldump
+	// EngineNative dumps over the MySQL protocol without client tools
+	EngineNative = engine.Native
+)
+
+// Layouts of Job.Layout.
+const (
+	// LayoutDatabase writes one file per dump
+	LayoutDatabase = "database"
+	// LayoutPerTable writes the schema and the data of every table to files
+	// of their own
+	LayoutPerTable = dumper.LayoutPerTable
+)
+
+// Job describes a backup. Its fields correspond to the flags of the
+// mymagicdump command; a zero value selects the default of the flag unless
+// documented otherwise.
+type Job struct {
+	// Name identifies the job in log messages, events and metrics
+	Name       string
+	Connection Connection
+
+	// Databases are the databases to dump; entries may be glob patterns
+	// (* and ?). AllDatabases dumps every database instead.
+	Databases    []string
+	AllDatabases bool
+	// SeparateDumps writes one dump per database of Databases
+	SeparateDumps bool
+	// Exclude holds db.table patterns of tables left out of the dumps, and
+	// ExcludeData those of tables dumped without their data
+	Exclude     []string
+	ExcludeData []string
+	// Engine is EngineMysqldump or EngineNative (default: EngineMysqldump)
+	Engine string
+	// Layout is LayoutDatabase or LayoutPerTable (default: LayoutDatabase)
+	Layout string
+	// ConsistentSnapshot makes all dumps see the same data. The global read
+	// lock it takes is held until the dumps finish with EngineMysqldump and
+	// only while the dump transactions start with EngineNative
+	ConsistentSnapshot bool
+	// RemoveDefiners removes DEFINER clauses from the dumps
+	RemoveDefiners bool
+	// MysqldumpArgs are passed to mysqldump in every dump
+	MysqldumpArgs []string
+
+	// Output is the directory the dumps are written to (default: the
+	// current directory)
+	Output string
+	// Timestamped writes the run into a timestamped subdirectory of Output
+	Timestamped bool
+	Retention   Retention
+	Compression Compression
+	// Encryption encrypts the output with age when set
+	Encryption *Encryption
+	Upload     Upload
+	// NoManifest leaves out MANIFEST.json; SHA256Sums also writes SHA256SUMS
+	NoManifest bool
+	SHA256Sums bool
+
+	// Retries is the number of times a failed dump or upload is retried,
+	// after RetryInterval; unlike the command, there are no retries by
+	// default
+	Retries       int
+	RetryInterval time.Duration
+	// Parallel is the number of dumps of SeparateDumps or LayoutPerTable
+	// that run at the same time (default: 1)
+	Parallel int
+	// DryRun logs the dumps without running them
+	DryRun bool
+
+	Notify  Notify
+	Metrics Metrics
+
+	// Observer receives the events and the log messages of the run
+	Observer Observer
+	// Events receives the events of the run as JSON lines, in the format
+	// of --events=json
+	Events io.Writer
+	// Console shows progress bars, mysqldump errors and the summary of the
+	// run on the terminal like the mymagicdump command. With Quiet only the
+	// summary of a failed run is printed, like with --silent.
+	Console bool
+	Quiet   bool
+}
+
+// Connection holds the settings used to connect to the MySQL server. At most
+// one of Password, PasswordFile, PasswordEnv, PasswordCommand and
+// PromptPassword can be set.
+type Connection struct {
+	Host   string
+	Port   int
+	Socket string
+	User   string
+	// Password is the password itself. PasswordFile reads it from the first
+	// line of a file, PasswordEnv from an environment variable and
+	// PasswordCommand from the output of a shell command. PromptPassword
+	// asks for it on the terminal.
+	Password        string
+	PasswordFile    string
+	PasswordEnv     string
+	PasswordCommand string
+	PromptPassword  bool
+	// DefaultsFile is a MySQL option file read for the connection; unlike
+	// the command, which reads ~/.my.cnf, none is read by default
+	DefaultsFile        string
+	DefaultsGroupSuffix string
+}
+
+// Retention keeps the given numbers of timestamped runs in Output and on the
+// upload destinations and removes older ones. Setting any of them implies
+// Job.Timestamped.
+type Retention struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+}
+
+// Compression selects how the dumps are compressed.
+type Compression struct {
+	// Type is a tar archive (tgz, tbz2, tzst, txz, tlz4), zip, per-file
+	// (gz, zst) or none (default: none)
+	Type string
+	// Level is the compression level; 0 uses the default of the format
+	Level int
+	// Threads is the number of threads of gzip, zstd and lz4; 0 uses one per
+	// CPU
+	Threads int
+	// Stream compresses the dumps while they are written instead of
+	// afterwards
+	Stream bool
+}
+
+// Encryption encrypts the output with age, to Recipients and the recipients
+// read from RecipientsFile, or with the passphrase read from PassphraseFile.
+// It implies Compression.Stream.
+type Encryption struct {
+	Recipients     []string
+	RecipientsFile string
+	PassphraseFile string
+}
+
+// Upload copies the finished files to remote destinations.
+type Upload struct {
+	// URLs are s3://bucket/prefix and sftp://user@host/path destinations
+	URLs []string
+	// Destinations are uploaded to besides URLs
+	Destinations []Destination
+	// DeleteAfterUpload deletes local files once every destination has them
+	DeleteAfterUpload bool
+	S3                S3
+	SFTP              SFTP
+}
+
+// Destination is a remote location the finished files are copied to, such
+// as a storage service of the program embedding mymagicdump.
+type Destination interface {
+	// Upload copies the local file to name, a slash separated path relative
+	// to the root of the destination.
+	Upload(ctx context.Context, localPath, name string) error
+	// List returns the names of the entries (files and directories) in the
+	// root of the destination, for Retention.
+	List(ctx context.Context) ([]string, error)
+	// Remove deletes the entry name in the root of the destination and
+	// everything below it.
+	Remove(ctx context.Context, name string) error
+	// String returns the destination for log messages.
+	String() string
+}
+
+// S3 holds the settings of s3:// upload URLs.
+type S3 struct {
+	// Endpoint is the host[:port] of the service (default: s3.amazonaws.com)
+	Endpoint     string
+	Region       string
+	PathStyle    bool
+	StorageClass string
+	// PartSize is the multipart upload part size in MiB (default: 64)
+	PartSize   int
+	DisableTLS bool
+}
+
+// SFTP holds the settings of sftp:// upload URLs.
+type SFTP struct {
+	// Identities are SSH private keys (default: ~/.ssh/id_ed25519,
+	// id_ecdsa, id_rsa)
+	Identities []string
+	// KnownHosts verifies the servers (default: ~/.ssh/known_hosts)
+	KnownHosts string
+}
+
+// Notify sends a report of the run by email and to webhooks.
+type Notify struct {
+	// Emails are the addresses the summary of the run is sent to
+	Emails []string
+	// OnFailure only notifies when the backup failed
+	OnFailure bool
+	SMTP      SMTP
+	// Webhooks receive a POST of the JSON report of the run, signed with
+	// WebhookSecret when it is set. WebhookTemplate is json, slack or a Go
+	// text/template file (default: json).
+	Webhooks        []string
+	WebhookSecret   string
+	WebhookTemplate string
+}
+
+// SMTP holds the settings of the server notification emails are sent with.
+type SMTP struct {
+	// Host defaults to localhost and Port to 465 with TLS tls, 25 otherwise
+	Host string
+	Port int
+	// TLS is auto (STARTTLS when offered), starttls, tls or none
+	// (default: auto)
+	TLS      string
+	User     string
+	Password string
+	// From defaults to mymagicdump@<hostname>
+	From string
+}
+
+// Metrics writes the Prometheus metrics of the run for the node_exporter
+// textfile collector.
+type Metrics struct {
+	// TextfileDir is the directory mymagicdump_<job>.prom is written to
+	TextfileDir string
+	// Job is the value of the job label (default: Job.Name, or mymagicdump)
+	Job string
+}
+
+// Run runs the backup until it is done or ctx is cancelled, which stops the
+// dumps, retries and uploads. Settings that are invalid or a server that
+// cannot be reached return an error without a Result. Otherwise Run returns
+// the Result of the run, together with a *RunError when the backup did not
+// fully succeed.
+func (j *Job) Run(ctx context.Context) (*Result, error) {
+	r := dumper.NewRunner(j.options())
+	r.EventOutput = j.Events
+	r.Headless = !j.Console
+	for _, dest := range j.Upload.Destinations {
+		r.AddDestination(dest)
+	}
+	if j.Observer != nil {
+		o := &observer{Observer: j.Observer, runID: r.RunID, job: j.Name}
+		r.OnEvent = o.event
+		r.OnLog = o.log
+	}
+	if err := r.Prepare(); err != nil {
+		return nil, err
+	}
+	err := r.Run(ctx)
+	return newResult(r), newRunError(err)
+}
+
+// options returns the dump options of the job.
+func (j *Job) options() *config.Options {
+	defaults := config.DefaultOptions()
+	opts := &config.Options{
+		ConnectionOptions: config.ConnectionOptions{
+			User:                j.Connection.User,
+			Password:            j.Connection.Password,
+			PasswordFile:        j.Connection.PasswordFile,
+			PasswordEnv:         j.Connection.PasswordEnv,
+			PasswordCommand:     j.Connection.PasswordCommand,
+			Host:                j.Connection.Host,
+			Socket:              j.Connection.Socket,
+			DefaultsFile:        j.Connection.DefaultsFile,
+			DefaultsGroupSuffix: j.Connection.DefaultsGroupSuffix,
+		},
+		AllDatabases:       j.AllDatabases,
+		Databases:          j.Databases,
+		SeparateDumps:      j.SeparateDumps,
+		Engine:             cmp.Or(j.Engine, defaults.Engine),
+		Layout:             cmp.Or(j.Layout, defaults.Layout),
+		ConsistentSnapshot: j.ConsistentSnapshot,
+		ExcludeTables:      j.Exclude,
+		ExcludeTablesData:  j.ExcludeData,
+		OutputPath:         cmp.Or(j.Output, defaults.OutputPath),
+		Timestamped:        j.Timestamped,
+		KeepLast:           j.Retention.KeepLast,
+		KeepDaily:          j.Retention.KeepDaily,
+		KeepWeekly:         j.Retention.KeepWeekly,
+		KeepMonthly:        j.Retention.KeepMonthly,
+		Compression:        cmp.Or(j.Compression.Type, defaults.Compression),
+		CompressionLevel:   j.Compression.Level,
+		CompressionThreads: j.Compression.Threads,
+		Stream:             j.Compression.Stream,
+		Upload:             j.Upload.URLs,
+		S3Endpoint:         cmp.Or(j.Upload.S3.Endpoint, defaults.S3Endpoint),
+		S3Region:           j.Upload.S3.Region,
+		S3PathStyle:        j.Upload.S3.PathStyle,
+		S3StorageClass:     j.Upload.S3.StorageClass,
+		S3PartSize:         cmp.Or(j.Upload.S3.PartSize, defaults.S3PartSize),
+		S3DisableTLS:       j.Upload.S3.DisableTLS,
+		SFTPIdentities:     j.Upload.SFTP.Identities,
+		SFTPKnownHosts:     cmp.Or(j.Upload.SFTP.KnownHosts, defaults.SFTPKnownHosts),
+		DeleteAfterUpload:  j.Upload.DeleteAfterUpload,
+		NoManifest:         j.NoManifest,
+		SHA256Sums:         j.SHA256Sums,
+		DryRun:             j.DryRun,
+		RemoveDefiners:     j.RemoveDefiners,
+		Retries:            j.Retries,
+		RetryInterval:      int(j.RetryInterval / time.Second),
+		Jobs:               cmp.Or(j.Parallel, 1),
+		NotifyEmail:        j.Notify.Emails,
+		NotifyOnFailure:    j.Notify.OnFailure,
+		Webhooks:           j.Notify.Webhooks,
+		WebhookSecret:      j.Notify.WebhookSecret,
+		WebhookTemplate:    cmp.Or(j.Notify.WebhookTemplate, defaults.WebhookTemplate),
+		MetricsTextfileDir: j.Metrics.TextfileDir,
+		MetricsJob:         j.Metrics.Job,
+		SMTPHost:           cmp.Or(j.Notify.SMTP.Host, defaults.SMTPHost),
+		SMTPPort:           j.Notify.SMTP.Port,
+		SMTPTLS:            cmp.Or(j.Notify.SMTP.TLS, defaults.SMTPTLS),
+		SMTPUser:           j.Notify.SMTP.User,
+		SMTPPassword:       j.Notify.SMTP.Password,
+		SMTPFrom:           j.Notify.SMTP.From,
+		Silent:             j.Quiet,
+		Passthrough:        j.MysqldumpArgs,
+		Job:                j.Name,
+	}
+	if j.Connection.Port != 0 {
+		opts.Port = strconv.Itoa(j.Connection.Port)
+	}
+	if j.Connection.PromptPassword {
+		opts.Password = config.PromptPassword
+	}
+	if e := j.Encryption; e != nil {
+		opts.Encrypt = true
+		opts.EncryptRecipients = e.Recipients
+		opts.RecipientsFile = e.RecipientsFile
+		opts.PassphraseFile = e.PassphraseFile
+	}
+	return opts
+}
@@ -0,0 +1,225 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mymagicdump
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+	"github.com/trustservers-hosting/mymagicdump/internal/logging"
+)
+
+// Observer receives the events and the log messages of a run. Its methods
+// are called one at a time, from the goroutines of the run, and should
+// return quickly.
+type Observer interface {
+	Event(e Event)
+	Log(m LogMessage)
+}
+
+// ObserverFuncs is an Observer calling OnEvent and OnLog; either may be nil.
+type ObserverFuncs struct {
+	OnEvent func(e Event)
+	OnLog   func(m LogMessage)
+}
+
+func (o ObserverFuncs) Event(e Event) {
+	if o.OnEvent != nil {
+		o.OnEvent(e)
+	}
+}
+
+func (o ObserverFuncs) Log(m LogMessage) {
+	if o.OnLog != nil {
+		o.OnLog(m)
+	}
+}
+
+// EventType names an event, as in the event field of --events=json.
+type EventType string
+
+const (
+	EventRunStarted          EventType = "run_started"
+	EventDumpStarted         EventType = "dump_started"
+	EventDumpProgress        EventType = "dump_progress"
+	EventDumpRetry           EventType = "dump_retry"
+	EventDumpFinished        EventType = "dump_finished"
+	EventCompressionStarted  EventType = "compression_started"
+	EventCompressionProgress EventType = "compression_progress"
+	EventCompressionFinished EventType = "compression_finished"
+	EventUploadStarted       EventType = "upload_started"
+	EventUploadFinished      EventType = "upload_finished"
+	EventRunFinished         EventType = "run_finished"
+)
+
+// Event is a step or the progress of a run. Besides Type, Time, RunID and
+// Job, only the fields of the type of event are set, as listed for
+// --events=json in the README.
+type Event struct {
+	Type  EventType
+	Time  time.Time
+	RunID string
+	Job   string
+
+	// Dumps is the number of dumps, Output the output directory and DryRun
+	// whether nothing is executed (run_started)
+	Dumps  int
+	Output string
+	DryRun bool
+	// Dump is the file name of the dump, Databases its databases and
+	// Attempt the number of the attempt (dump_*)
+	Dump      string
+	Databases []string
+	Attempt   int
+	// Attempts is the number of attempts made (dump_finished)
+	Attempts int
+	// RetryDelay is the time until the next attempt (dump_retry)
+	RetryDelay time.Duration
+	// Compression is the compression type (compression_started)
+	Compression string
+	// Destination is the upload destination (upload_*)
+	Destination string
+	// Bytes is the number of bytes dumped, compressed or written,
+	// EstimatedBytes the size of the dump reported by the server and
+	// TotalBytes the number of bytes to compress
+	Bytes          int64
+	EstimatedBytes int64
+	TotalBytes     int64
+	// Files is the number of files compressed or uploaded
+	Files int
+	// Status is success or failed for a step, and the Status of the run for
+	// run_finished, or error when the run could not start
+	Status   string
+	Duration time.Duration
+	// Error is the error of a failed step, Errors all errors of the run and
+	// ExitCode its exit code (run_finished)
+	Error    string
+	Errors   []string
+	ExitCode int
+}
+
+// LogMessage is a message logged by a run.
+type LogMessage struct {
+	Time time.Time
+	// Level is debug, info, warning or error
+	Level   string
+	Message string
+	// Fields tell what the message belongs to: run_id, job, phase,
+	// database and attempt
+	Fields map[string]any
+}
+
+// SetLogOutput sets where log messages are written, os.Stderr by default.
+// With io.Discard, an Observer is the only receiver of the messages of runs.
+func SetLogOutput(w io.Writer) {
+	logging.SetOutput(w)
+}
+
+// observer passes the events and messages of a run to an Observer, one at a
+// time.
+type observer struct {
+	Observer
+	mu    sync.Mutex
+	runID string
+	job   string
+}
+
+func (o *observer) event(e dumper.Event) {
+	ev := Event{Type: EventType(e.Name), Time: e.Time, RunID: o.runID, Job: o.job}
+	for _, f := range e.Fields {
+		switch v := f.Value.(type) {
+		case int:
+			switch f.Key {
+			case "dumps":
+				ev.Dumps = v
+			case "attempt":
+				ev.Attempt = v
+			case "attempts":
+				ev.Attempts = v
+			case "files":
+				ev.Files = v
+			case "delay_seconds":
+				ev.RetryDelay = time.Duration(v) * time.Second
+			case "exit_code":
+				ev.ExitCode = v
+			}
+		case int64:
+			switch f.Key {
+			case "bytes":
+				ev.Bytes = v
+			case "estimated_bytes":
+				ev.EstimatedBytes = v
+			case "total_bytes":
+				ev.TotalBytes = v
+			}
+		case float64:
+			if f.Key == "duration_seconds" {
+				ev.Duration = time.Duration(v * float64(time.Second))
+			}
+		case bool:
+			if f.Key == "dry_run" {
+				ev.DryRun = v
+			}
+		case string:
+			switch f.Key {
+			case "output":
+				ev.Output = v
+			case "dump":
+				ev.Dump = v
+			case "type":
+				ev.Compression = v
+			case "destination":
+				ev.Destination = v
+			case "status":
+				ev.Status = v
+			case "error":
+				ev.Error = v
+			}
+		case []string:
+			switch f.Key {
+			case "databases":
+				ev.Databases = v
+			case "errors":
+				ev.Errors = v
+			case "files":
+				ev.Files = len(v)
+			}
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Event(ev)
+}
+
+func (o *observer) log(e logging.Entry) {
+	m := LogMessage{Time: e.Time, Level: e.Level, Message: e.Message, Fields: make(map[string]any, len(e.Fields))}
+	for _, f := range e.Fields {
+		m.Fields[f.Key] = f.Value
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Log(m)
+}
@@ -0,0 +1,185 @@
+/*
+    mymagicdump
+
+	A fast, scriptable MySQL/MariaDB backup wrapper around mysqldump
+	with exclusions, retries, progress, optional compression and more
+
+	Copyright (C) 2025 Trustservers PC
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mymagicdump
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trustservers-hosting/mymagicdump/internal/dumper"
+)
+
+// Status classifies the outcome of a run.
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	// StatusPartialFailure means some, but not all, dumps failed
+	StatusPartialFailure Status = "partial_failure"
+	// StatusTotalFailure means every dump failed
+	StatusTotalFailure Status = "total_failure"
+	// StatusCompressionFailure means the archive could not be written
+	StatusCompressionFailure Status = "compression_failure"
+	// StatusUploadFailure means the backup succeeded locally but an upload
+	// failed
+	StatusUploadFailure Status = "upload_failure"
+	// StatusOutputFailure means MANIFEST.json or RESTORE_ORDER.json could
+	// not be written
+	StatusOutputFailure Status = "output_failure"
+)
+
+// Exit codes of the mymagicdump command. ExitError is used for failures
+// before any dump started, such as invalid settings.
+const (
+	ExitSuccess            = dumper.ExitSuccess
+	ExitError              = dumper.ExitError
+	ExitPartialFailure     = dumper.ExitPartialFailure
+	ExitTotalFailure       = dumper.ExitTotalFailure
+	ExitCompressionFailure = dumper.ExitCompressionFailure
+	ExitUploadFailure      = dumper.ExitUploadFailure
+	ExitOutputFailure      = dumper.ExitOutputFailure
+)
+
+// ExitCode returns the exit code of the mymagicdump command for s.
+func (s Status) ExitCode() int {
+	switch s {
+	case StatusSuccess:
+		return ExitSuccess
+	case StatusPartialFailure:
+		return ExitPartialFailure
+	case StatusTotalFailure:
+		return ExitTotalFailure
+	case StatusCompressionFailure:
+		return ExitCompressionFailure
+	case StatusUploadFailure:
+		return ExitUploadFailure
+	case StatusOutputFailure:
+		return ExitOutputFailure
+	}
+	return ExitError
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	RunID    string
+	Status   Status
+	Started  time.Time
+	Finished time.Time
+	// Output is the directory the run was written to, a subdirectory of
+	// Job.Output with Job.Timestamped
+	Output string
+	// CompressedBytes is the size of the compressed dump files, 0 without
+	// compression
+	CompressedBytes int64
+	// Dumps holds the outcome of every dump in the order of the databases
+	Dumps []Dump
+	// Files are the files written, after compression
+	Files []File
+	// Errors holds every failure of the run, without secrets
+	Errors []string
+}
+
+// Dump is the outcome of one dump, including its retries.
+type Dump struct {
+	// Name is the dump file name relative to Result.Output, e.g. shop.sql
+	Name      string
+	Databases []string
+	// EstimatedBytes is the size reported by the server before dumping
+	EstimatedBytes int64
+	// Bytes, Duration and Err describe the last attempt
+	Bytes    int64
+	Duration time.Duration
+	Attempts int
+	Err      error
+}
+
+// File is a file written by a run.
+type File struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// newResult returns the result of the finished run of r.
+func newResult(r *dumper.Runner) *Result {
+	rep := r.Report()
+	res := &Result{
+		RunID:           rep.RunID,
+		Status:          Status(rep.Status),
+		Started:         rep.Started,
+		Finished:        rep.Finished,
+		Output:          rep.OutputPath,
+		CompressedBytes: rep.CompressedBytes,
+		Errors:          rep.Errors,
+	}
+	for _, d := range r.Results {
+		res.Dumps = append(res.Dumps, Dump{
+			Name:           d.Name,
+			Databases:      d.Databases,
+			EstimatedBytes: d.EstimatedBytes,
+			Bytes:          d.Bytes,
+			Duration:       d.Duration,
+			Attempts:       d.Attempts,
+			Err:            d.Err,
+		})
+	}
+	for _, f := range rep.Files {
+		res.Files = append(res.Files, File{Path: f.Path, Size: f.Size, SHA256: f.SHA256})
+	}
+	return res
+}
+
+// RunError is returned by Job.Run when the backup did not fully succeed.
+type RunError struct {
+	Status Status
+	Errors []string
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("backup finished with %s: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// newRunError returns the RunError for an error of dumper.Runner.Run.
+func newRunError(err error) error {
+	var runErr *dumper.RunError
+	if !errors.As(err, &runErr) {
+		return err
+	}
+	return &RunError{Status: Status(runErr.Status.String()), Errors: runErr.Errors}
+}
+
+// ExitCode returns the exit code of the mymagicdump command for an error
+// returned by Job.Run.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.Status.ExitCode()
+	}
+	return ExitError
+}